@@ -3,12 +3,14 @@ package ast
 import (
 	"bytes"
 	"dumch/monkey/token"
+	"math/big"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // position of the node's token
 }
 
 type Statement interface {
@@ -41,6 +43,13 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 type LetStatement struct {
 	Token token.Token // the token.LET
 	Name  *Identifier
@@ -49,6 +58,7 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral())
@@ -69,6 +79,7 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
 func (i *Identifier) String() string       { return i.Value }
 
 type ReturnStatement struct {
@@ -78,6 +89,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(rs.TokenLiteral())
@@ -96,6 +108,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -110,8 +123,30 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+// BigIntLiteral is an integer literal too large for an int64
+type BigIntLiteral struct {
+	Token token.Token
+	Value *big.Int
+}
+
+func (bl *BigIntLiteral) expressionNode()      {}
+func (bl *BigIntLiteral) TokenLiteral() string { return bl.Token.Literal }
+func (bl *BigIntLiteral) Pos() token.Position  { return bl.Token.Pos }
+func (bl *BigIntLiteral) String() string       { return bl.Token.Literal }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -119,6 +154,7 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 type PrefixExpression struct {
@@ -129,6 +165,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteByte('(')
@@ -147,6 +184,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteByte('(')
@@ -166,6 +204,7 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 type IfExpression struct {
@@ -177,6 +216,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if")
@@ -197,6 +237,7 @@ type BlockStatement struct {
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, stmt := range bs.Statements {
@@ -209,10 +250,12 @@ type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Name       string // the name of the let binding, if any
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := make([]string, len(fl.Parameters))
@@ -220,6 +263,11 @@ func (fl *FunctionLiteral) String() string {
 		params[i] = p.String()
 	}
 	out.WriteString(fl.TokenLiteral())
+	if fl.Name != "" {
+		out.WriteByte('<')
+		out.WriteString(fl.Name)
+		out.WriteByte('>')
+	}
 	out.WriteByte('(')
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteByte(')')
@@ -235,6 +283,7 @@ type CallExpression struct {
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Token.Pos }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := make([]string, len(ce.Arguments), len(ce.Arguments))
@@ -255,6 +304,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elements := make([]string, len(al.Elements))
@@ -275,6 +325,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteByte('(')
@@ -292,6 +343,7 @@ type HashLiteral struct {
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
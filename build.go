@@ -0,0 +1,78 @@
+package main
+
+import (
+	"dumch/monkey/bytecode"
+	"dumch/monkey/compiler"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func buildCommand(args []string, stdin io.Reader, stderr io.Writer) int {
+	flags := newFlagSet("build", stderr)
+	output := flags.String("o", "", "output file, defaults to the input with .mkc")
+	strip := flags.Bool("strip", false, "omit line tables from the output")
+	opts := compileFlags(flags)
+	files, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(files) != 1 {
+		fmt.Fprintf(stderr, "monkey: build expects one file, got %d\n\n%s",
+			len(files), usage)
+		return exitUsage
+	}
+
+	out, ok := outputName("build", files[0], *output, stderr)
+	if !ok {
+		return exitUsage
+	}
+
+	filename, source, err := readSource(files[0], stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitUsage
+	}
+
+	program, ok := parseSource(filename, source, stderr)
+	if !ok {
+		return exitError
+	}
+	bc, ok := compileProgram(program, opts, stderr)
+	if !ok {
+		return exitError
+	}
+
+	return writeBytecode(out, bc, !*strip, stderr)
+}
+
+// outputName picks the .mkc file written for the input file
+func outputName(command, file, output string, stderr io.Writer) (string, bool) {
+	if output != "" {
+		return output, true
+	}
+	if file == "-" {
+		fmt.Fprintf(stderr, "monkey: %s from stdin needs -o\n", command)
+		return "", false
+	}
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ".mkc", true
+}
+
+func writeBytecode(out string, bc *compiler.Bytecode, debug bool, stderr io.Writer) int {
+	f, err := os.Create(out)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitUsage
+	}
+	err = bytecode.Encode(f, bc, debug)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,406 @@
+package bytecode
+
+import (
+	"bufio"
+	"dumch/monkey/code"
+	"dumch/monkey/compiler"
+	"dumch/monkey/object"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Assemble reads a listing in the format written by Disassemble and
+// returns the bytecode it describes. Leading instruction offsets and
+// comments after ';' are ignored, so a listing can be edited freely:
+//
+//	.const
+//	    #0 int 1
+//	.main
+//	    OpTrue
+//	    OpJumpNotTruthy else
+//	    OpConstant 0
+//	else:
+//	    OpPop
+//
+// Labels are local to .main and to each .func block. Jump operands may be
+// labels or plain offsets, .byte N emits a raw byte. Without a stack=N
+// attribute the maximum stack depth is computed from the instructions.
+func Assemble(r io.Reader) (*compiler.Bytecode, error) {
+	a := &assembler{
+		scanner:   bufio.NewScanner(r),
+		constants: []object.Object{},
+	}
+
+	var main *block
+	for a.next() {
+		switch {
+		case len(a.fields) == 0:
+			continue
+		case a.fields[0] == ".const" && len(a.fields) == 1:
+			if err := a.constSection(); err != nil {
+				return nil, err
+			}
+		case a.fields[0] == ".main":
+			if main != nil {
+				return nil, a.errorf("duplicate .main")
+			}
+			main = newBlock()
+			if err := a.attributes(a.fields[1:], map[string]*int{
+				"stack": &main.maxStack,
+			}); err != nil {
+				return nil, err
+			}
+			if err := a.instructions(main, ""); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, a.errorf("expected .const or .main, got %s", a.fields[0])
+		}
+	}
+	if a.err != nil {
+		return nil, a.err
+	}
+
+	if main == nil {
+		return nil, fmt.Errorf("missing .main")
+	}
+	ins, err := main.assemble()
+	if err != nil {
+		return nil, err
+	}
+	return &compiler.Bytecode{
+		Instructions: ins,
+		Constants:    a.constants,
+		MaxStack:     main.stackSize(ins),
+	}, nil
+}
+
+type assembler struct {
+	scanner *bufio.Scanner
+	line    int
+	fields  []string
+	err     error
+
+	// pushedBack makes next return the current line again, it is set by
+	// a section that read the first line of the following one
+	pushedBack bool
+
+	constants []object.Object
+}
+
+// next reads the fields of the following line
+func (a *assembler) next() bool {
+	if a.pushedBack {
+		a.pushedBack = false
+		return true
+	}
+	if a.err != nil || !a.scanner.Scan() {
+		if a.err == nil {
+			a.err = a.scanner.Err()
+		}
+		return false
+	}
+	a.line++
+	a.fields, a.err = splitFields(a.scanner.Text())
+	if a.err != nil {
+		a.err = a.errorf("%s", a.err)
+		return false
+	}
+	return true
+}
+
+func (a *assembler) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", a.line, fmt.Sprintf(format, args...))
+}
+
+// constSection reads constants up to the next section
+func (a *assembler) constSection() error {
+	for a.next() {
+		if len(a.fields) == 0 {
+			continue
+		}
+		if a.fields[0] == ".main" || a.fields[0] == ".const" {
+			a.pushedBack = true
+			return nil
+		}
+		if err := a.constant(); err != nil {
+			return err
+		}
+	}
+	return a.err
+}
+
+func (a *assembler) constant() error {
+	fields := a.fields
+	if strings.HasPrefix(fields[0], "#") {
+		index, err := strconv.Atoi(fields[0][1:])
+		if err != nil {
+			return a.errorf("bad constant index %s", fields[0])
+		}
+		if index != len(a.constants) {
+			return a.errorf("constant #%d out of order, want #%d",
+				index, len(a.constants))
+		}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return a.errorf("missing constant")
+	}
+
+	switch fields[0] {
+	case "int":
+		if len(fields) != 2 {
+			return a.errorf("int takes one value")
+		}
+		value, ok := new(big.Int).SetString(fields[1], 10)
+		if !ok {
+			return a.errorf("bad int %s", fields[1])
+		}
+		a.constants = append(a.constants, object.NewInteger(value))
+	case "float":
+		if len(fields) != 2 {
+			return a.errorf("float takes one value")
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return a.errorf("bad float %s", fields[1])
+		}
+		a.constants = append(a.constants, &object.Float{Value: value})
+	case "string":
+		if len(fields) != 2 {
+			return a.errorf("string takes one value")
+		}
+		value, err := strconv.Unquote(fields[1])
+		if err != nil {
+			return a.errorf("bad string %s", fields[1])
+		}
+		a.constants = append(a.constants, &object.String{Value: value})
+	case ".func":
+		return a.function(fields[1:])
+	default:
+		return a.errorf("unknown constant kind %s", fields[0])
+	}
+	return nil
+}
+
+// function reads a .func header and its instructions up to .end
+func (a *assembler) function(header []string) error {
+	fn := &object.CompiledFunction{}
+
+	if len(header) > 0 && strings.HasPrefix(header[0], `"`) {
+		name, err := strconv.Unquote(header[0])
+		if err != nil {
+			return a.errorf("bad function name %s", header[0])
+		}
+		fn.Name = name
+		header = header[1:]
+	}
+
+	b := newBlock()
+	err := a.attributes(header, map[string]*int{
+		"params": &fn.NumParameters,
+		"locals": &fn.NumLocals,
+		"stack":  &b.maxStack,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := a.instructions(b, ".end"); err != nil {
+		return err
+	}
+	ins, err := b.assemble()
+	if err != nil {
+		return err
+	}
+	fn.Instructions = ins
+	fn.MaxStack = b.stackSize(ins)
+
+	a.constants = append(a.constants, fn)
+	return nil
+}
+
+// attributes parses key=value pairs into the given fields
+func (a *assembler) attributes(fields []string, into map[string]*int) error {
+	for _, attr := range fields {
+		key, value, _ := strings.Cut(attr, "=")
+		field, ok := into[key]
+		if !ok {
+			return a.errorf("unknown attribute %s", key)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return a.errorf("bad %s", attr)
+		}
+		*field = n
+	}
+	return nil
+}
+
+// instructions reads lines into b until the end directive, or until the
+// end of input when end is empty
+func (a *assembler) instructions(b *block, end string) error {
+	for a.next() {
+		fields := a.fields
+		if len(fields) == 0 {
+			continue
+		}
+		if end != "" && fields[0] == end && len(fields) == 1 {
+			return nil
+		}
+		if end == "" && (fields[0] == ".const" || fields[0] == ".main") {
+			a.pushedBack = true
+			return nil
+		}
+
+		if len(fields) == 1 && strings.HasSuffix(fields[0], ":") {
+			name := strings.TrimSuffix(fields[0], ":")
+			if _, ok := b.labels[name]; ok {
+				return a.errorf("duplicate label %s", name)
+			}
+			b.labels[name] = b.offset
+			continue
+		}
+
+		// offsets printed by the disassembler
+		if _, err := strconv.Atoi(fields[0]); err == nil && len(fields) > 1 {
+			fields = fields[1:]
+		}
+
+		if fields[0] == ".byte" {
+			if len(fields) != 2 {
+				return a.errorf(".byte takes one value")
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 0 || n > 255 {
+				return a.errorf("bad byte %s", fields[1])
+			}
+			b.items = append(b.items, item{line: a.line, raw: byte(n)})
+			b.offset++
+			continue
+		}
+
+		op, def, err := code.LookupName(fields[0])
+		if err != nil {
+			return a.errorf("%s", err)
+		}
+		args := fields[1:]
+		if len(args) != len(def.OperandWidth) {
+			return a.errorf("%s takes %d operands, got %d",
+				def.Name, len(def.OperandWidth), len(args))
+		}
+		b.items = append(b.items, item{line: a.line, op: op, def: def, args: args})
+		b.offset += def.Size()
+	}
+	if a.err != nil {
+		return a.err
+	}
+	if end != "" {
+		return a.errorf("missing %s", end)
+	}
+	return nil
+}
+
+// block collects the instructions of one function, jumps are resolved
+// once all labels are known
+type block struct {
+	items  []item
+	labels map[string]int
+	offset int
+
+	maxStack int // -1 when not given
+}
+
+type item struct {
+	line int
+	def  *code.Definition // nil for a raw byte
+	op   code.Opcode
+	args []string
+	raw  byte
+}
+
+func newBlock() *block {
+	return &block{labels: map[string]int{}, maxStack: -1}
+}
+
+// stackSize is the given max stack, or the one ins needs. Malformed code
+// has no meaningful depth and gets 0.
+func (b *block) stackSize(ins code.Instructions) int {
+	if b.maxStack >= 0 {
+		return b.maxStack
+	}
+	depth, _ := code.MaxStackDepth(ins)
+	return depth
+}
+
+func (b *block) assemble() (code.Instructions, error) {
+	ins := code.Instructions{}
+
+	for _, it := range b.items {
+		if it.def == nil {
+			ins = append(ins, it.raw)
+			continue
+		}
+
+		operands := make([]int, len(it.args))
+		for i, arg := range it.args {
+			if target, ok := b.labels[arg]; ok && it.op.IsJump() {
+				operands[i] = target
+				continue
+			}
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				if it.op.IsJump() {
+					return nil, fmt.Errorf("line %d: undefined label %s", it.line, arg)
+				}
+				return nil, fmt.Errorf("line %d: bad operand %s", it.line, arg)
+			}
+			width := it.def.OperandWidth[i]
+			if n < 0 || n >= 1<<(8*width) {
+				return nil, fmt.Errorf("line %d: operand %d does not fit in %d bytes",
+					it.line, n, width)
+			}
+			operands[i] = n
+		}
+
+		ins = append(ins, code.Make(it.op, operands...)...)
+	}
+	return ins, nil
+}
+
+// splitFields splits a line on spaces, keeping quoted strings whole and
+// dropping the comment
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ';':
+			return fields, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			fields = append(fields, line[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r;\"", rune(line[j])) {
+				j++
+			}
+			fields = append(fields, line[i:j])
+			i = j
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,164 @@
+package bytecode
+
+import (
+	"bytes"
+	"dumch/monkey/code"
+	"dumch/monkey/compiler"
+	"dumch/monkey/object"
+	"strings"
+	"testing"
+)
+
+func TestAssembleRoundTrip(t *testing.T) {
+	inputs := []string{
+		`1 + 2; "a;b" + "c"`,
+		`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+		puts(fib(10));`,
+		`let adder = fn(a) { fn(b) { a + b } }; adder(1)(2);`,
+		`[1, 2, 3][0]; {"k": -7}["k"]; if (false) { 1 };`,
+		`let big = 18446744073709551616; big * 9223372036854775807`,
+		`let avg = fn(a, b) { (a + b) / 2.0 }; avg(1, 2.5e-3) + 0.1 - 1e300 * -0.0`,
+	}
+
+	for _, input := range inputs {
+		testRoundTrip(t, compile(t, input))
+	}
+
+	malformed := []code.Instructions{
+		{255, byte(code.OpPop)},
+		{byte(code.OpPop), byte(code.OpConstant), 0},
+		concat(code.Make(code.OpJump, 1), code.Make(code.OpPop)),
+		code.Make(code.OpJump, 3),
+		concat(code.Make(code.OpJumpWide, 5), code.Make(code.OpGetLocalWide, 300)),
+	}
+	for _, ins := range malformed {
+		testRoundTrip(t, &compiler.Bytecode{
+			Instructions: ins,
+			Constants:    []object.Object{},
+		})
+	}
+}
+
+func testRoundTrip(t *testing.T, original *compiler.Bytecode) {
+	t.Helper()
+
+	var listing bytes.Buffer
+	if err := Disassemble(&listing, original); err != nil {
+		t.Fatalf("disassemble error: %s", err)
+	}
+
+	assembled, err := Assemble(strings.NewReader(listing.String()))
+	if err != nil {
+		t.Fatalf("assemble error: %s\n%s", err, listing.String())
+	}
+
+	// the encoded form covers instructions and every constant
+	var want, got bytes.Buffer
+	if err := Encode(&want, original, false); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if err := Encode(&got, assembled, false); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if !bytes.Equal(want.Bytes(), got.Bytes()) {
+		t.Errorf("round trip changed the bytecode of\n%s", listing.String())
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	input := `
+		; hand written
+		.const
+		    int 5
+		    .func "inc" params=1 locals=1
+		        OpGetLocal 0
+		        OpConstant 0
+		        OpAdd
+		        OpReturnValue
+		    .end
+		.main
+		    OpTrue
+		    OpJumpNotTruthy skip ; forward
+		    OpClosure 1 0
+		    OpConstant 0
+		    OpCall 1
+		    OpJump done
+		skip:
+		    OpNull
+		done:
+		    OpPop
+	`
+
+	bc, err := Assemble(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("assemble error: %s", err)
+	}
+
+	expected := concat(
+		code.Make(code.OpTrue),
+		code.Make(code.OpJumpNotTruthy, 16),
+		code.Make(code.OpClosure, 1, 0),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpCall, 1),
+		code.Make(code.OpJump, 17),
+		code.Make(code.OpNull),
+		code.Make(code.OpPop),
+	)
+	if !bytes.Equal(bc.Instructions, expected) {
+		t.Errorf("wrong instructions.\nwant=%s\ngot =%s", expected, bc.Instructions)
+	}
+
+	if len(bc.Constants) != 2 {
+		t.Fatalf("wrong number of constants. want=2, got=%d", len(bc.Constants))
+	}
+	fn, ok := bc.Constants[1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 1 not a function. got=%T", bc.Constants[1])
+	}
+	if fn.Name != "inc" || fn.NumParameters != 1 || fn.NumLocals != 1 {
+		t.Errorf("wrong function header. got name=%q params=%d locals=%d",
+			fn.Name, fn.NumParameters, fn.NumLocals)
+	}
+
+	// without stack= attributes the depths are computed
+	if fn.MaxStack != 2 || bc.MaxStack != 2 {
+		t.Errorf("wrong max stack. want 2 and 2, got fn=%d main=%d",
+			fn.MaxStack, bc.MaxStack)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"OpPop", "line 1: expected .const or .main, got OpPop"},
+		{".const\n#0 int 1", "missing .main"},
+		{".main\nOpFoo", "line 2: opcode OpFoo undefined"},
+		{".main\nOpConstant", "line 2: OpConstant takes 1 operands, got 0"},
+		{".main\nOpConstant 70000", "line 2: operand 70000 does not fit in 2 bytes"},
+		{".main\nOpGetLocal x", "line 2: bad operand x"},
+		{".main\nOpJump nowhere", "line 2: undefined label nowhere"},
+		{".main\na:\na:", "line 3: duplicate label a"},
+		{".main\n.byte 256", "line 2: bad byte 256"},
+		{".main\n.main", "line 2: duplicate .main"},
+		{".const\n#1 int 1", "line 2: constant #1 out of order, want #0"},
+		{".const\nbool true", "line 2: unknown constant kind bool"},
+		{".const\nfloat 1.2.3", "line 2: bad float 1.2.3"},
+		{".const\nstring \"open", "line 2: unterminated string"},
+		{".const\n.func \"f\" arity=1\n.end", "line 2: unknown attribute arity"},
+		{".main stack=-1", "line 1: bad stack=-1"},
+		{".const\n.func \"f\"\nOpPop", "line 3: missing .end"},
+	}
+
+	for _, tt := range tests {
+		_, err := Assemble(strings.NewReader(tt.input))
+		if err == nil {
+			t.Errorf("%q: expected error %q", tt.input, tt.expected)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
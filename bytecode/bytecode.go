@@ -0,0 +1,319 @@
+// Package bytecode stores compiled programs in a binary file format.
+//
+// All numbers are varints (encoding/binary), signed ones zig-zag encoded.
+// A file is laid out as:
+//
+//	magic          "MKBC"
+//	format version uvarint
+//	code version   uvarint, must match code.Version
+//	flags          byte, FlagDebug when line tables are present
+//	main function  max stack, instructions [, line table]
+//	constants      count, then a tag byte and the value for each
+//	checksum       CRC-32 (IEEE) of everything above, 4 bytes big-endian
+//
+// Byte strings (instructions, strings, names) are a uvarint length followed
+// by the bytes. A line table is a filename string and its data. Functions
+// are stored as locals, parameters, max stack, name, instructions and the
+// optional line table, relative to where the function literal starts.
+package bytecode
+
+import (
+	"bytes"
+	"dumch/monkey/code"
+	"dumch/monkey/compiler"
+	"dumch/monkey/object"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/big"
+)
+
+const Magic = "MKBC"
+
+// FormatVersion is the version of the file layout
+const FormatVersion = 3
+
+const (
+	FlagDebug byte = 1 << iota // line tables are included
+)
+
+// Constant tags
+const (
+	tagInteger  byte = 1
+	tagString   byte = 2
+	tagFunction byte = 3
+	tagBigInt   byte = 4
+	tagFloat    byte = 5
+)
+
+var ErrBadMagic = errors.New("not a monkey bytecode file")
+
+// IsBytecode reports whether data starts with the bytecode magic header
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic))
+}
+
+// Encode writes bc to w. With debug set the line tables are written too.
+func Encode(w io.Writer, bc *compiler.Bytecode, debug bool) error {
+	e := &encoder{debug: debug}
+
+	e.buf.WriteString(Magic)
+	e.uvarint(FormatVersion)
+	e.uvarint(code.Version)
+	var flags byte
+	if debug {
+		flags |= FlagDebug
+	}
+	e.buf.WriteByte(flags)
+
+	e.uvarint(uint64(bc.MaxStack))
+	e.bytes(bc.Instructions)
+	e.lineTable(bc.LineTable)
+
+	e.uvarint(uint64(len(bc.Constants)))
+	for i, c := range bc.Constants {
+		if err := e.constant(c); err != nil {
+			return fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(e.buf.Bytes()))
+	e.buf.Write(sum[:])
+
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+type encoder struct {
+	buf   bytes.Buffer
+	debug bool
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.buf.Write(tmp[:n])
+}
+
+func (e *encoder) varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	e.buf.Write(tmp[:n])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) lineTable(lt code.LineTable) {
+	if !e.debug {
+		return
+	}
+	e.bytes([]byte(lt.Filename))
+	e.bytes(lt.Data)
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(tagInteger)
+		e.varint(obj.Value)
+	case *object.BigInt:
+		e.buf.WriteByte(tagBigInt)
+		e.bytes([]byte(obj.Value.String()))
+	case *object.Float:
+		e.buf.WriteByte(tagFloat)
+		e.uvarint(math.Float64bits(obj.Value))
+	case *object.String:
+		e.buf.WriteByte(tagString)
+		e.bytes([]byte(obj.Value))
+	case *object.CompiledFunction:
+		e.buf.WriteByte(tagFunction)
+		e.uvarint(uint64(obj.NumLocals))
+		e.uvarint(uint64(obj.NumParameters))
+		e.uvarint(uint64(obj.MaxStack))
+		e.bytes([]byte(obj.Name))
+		e.bytes(obj.Instructions)
+		e.lineTable(obj.LineTable)
+	default:
+		return fmt.Errorf("cannot encode %s", obj.Type())
+	}
+	return nil
+}
+
+// Decode reads a program written by Encode. Files of another format
+// or instruction set version are rejected, as are corrupted ones.
+func Decode(r io.Reader) (*compiler.Bytecode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsBytecode(data) {
+		return nil, ErrBadMagic
+	}
+	if len(data) < len(Magic)+4 {
+		return nil, fmt.Errorf("bytecode file is truncated")
+	}
+
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return nil, fmt.Errorf("bytecode checksum mismatch")
+	}
+
+	d := &decoder{data: body[len(Magic):]}
+
+	if v := d.uvarint(); d.err == nil && v != FormatVersion {
+		return nil, fmt.Errorf("unsupported bytecode format version %d, want %d",
+			v, FormatVersion)
+	}
+	if v := d.uvarint(); d.err == nil && v != code.Version {
+		return nil, fmt.Errorf("bytecode compiled for instruction set "+
+			"version %d, this monkey runs version %d", v, code.Version)
+	}
+	flags := d.byte()
+	d.debug = flags&FlagDebug != 0
+
+	bc := &compiler.Bytecode{}
+	bc.MaxStack = d.int()
+	bc.Instructions = d.bytes()
+	bc.LineTable = d.lineTable()
+
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.data)) {
+		d.fail("constant count %d exceeds file size", n)
+	}
+	bc.Constants = make([]object.Object, 0, n)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		bc.Constants = append(bc.Constants, d.constant())
+	}
+
+	if d.err == nil && len(d.data) != 0 {
+		d.fail("%d unexpected trailing bytes", len(d.data))
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return bc, nil
+}
+
+// decoder reads from data until the first error, which sticks
+type decoder struct {
+	data  []byte
+	debug bool
+	err   error
+}
+
+func (d *decoder) fail(format string, a ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("malformed bytecode: "+format, a...)
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) int() int {
+	v := d.uvarint()
+	if v > math.MaxInt32 {
+		d.fail("value %d out of range", v)
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.data)) {
+		d.fail("length %d exceeds remaining %d bytes", n, len(d.data))
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.data)
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) lineTable() code.LineTable {
+	if !d.debug {
+		return code.LineTable{}
+	}
+	filename := string(d.bytes())
+	data := d.bytes()
+	return code.LineTable{Filename: filename, Data: data}
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: d.varint()}
+	case tagBigInt:
+		text := string(d.bytes())
+		value, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			d.fail("bad big integer %q", text)
+			return nil
+		}
+		return object.NewInteger(value)
+	case tagFloat:
+		return &object.Float{Value: math.Float64frombits(d.uvarint())}
+	case tagString:
+		return &object.String{Value: string(d.bytes())}
+	case tagFunction:
+		fn := &object.CompiledFunction{}
+		fn.NumLocals = d.int()
+		fn.NumParameters = d.int()
+		fn.MaxStack = d.int()
+		fn.Name = string(d.bytes())
+		fn.Instructions = d.bytes()
+		fn.LineTable = d.lineTable()
+		return fn
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
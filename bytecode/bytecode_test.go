@@ -0,0 +1,163 @@
+package bytecode
+
+import (
+	"bytes"
+	"dumch/monkey/code"
+	"dumch/monkey/compiler"
+	"dumch/monkey/lexer"
+	"dumch/monkey/object"
+	"dumch/monkey/parser"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	input := `let greet = fn(name) { "hello " + name };
+		let add = fn(a) { fn(b) { a + b } };
+		greet("monkey");
+		add(-1)(1024);
+		add(18446744073709551616)(-36893488147419103232);
+		add(0.1)(-2.5e-300);`
+
+	original := compile(t, input)
+
+	for _, debug := range []bool{true, false} {
+		var buf bytes.Buffer
+		err := Encode(&buf, original, debug)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+
+		decoded, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+
+		if !bytes.Equal(decoded.Instructions, original.Instructions) {
+			t.Errorf("instructions differ.\nwant=%q\ngot =%q",
+				original.Instructions, decoded.Instructions)
+		}
+		if decoded.MaxStack != original.MaxStack {
+			t.Errorf("max stack wrong. want=%d, got=%d",
+				original.MaxStack, decoded.MaxStack)
+		}
+		testLineTable(t, debug, original.LineTable, decoded.LineTable)
+
+		if len(decoded.Constants) != len(original.Constants) {
+			t.Fatalf("wrong number of constants. want=%d, got=%d",
+				len(original.Constants), len(decoded.Constants))
+		}
+
+		for i, want := range original.Constants {
+			got := decoded.Constants[i]
+			switch want := want.(type) {
+			case *object.Integer, *object.BigInt, *object.Float, *object.String:
+				if got.Type() != want.Type() || got.Inspect() != want.Inspect() {
+					t.Errorf("constant %d wrong. want=%s, got=%s",
+						i, want.Inspect(), got.Inspect())
+				}
+			case *object.CompiledFunction:
+				fn, ok := got.(*object.CompiledFunction)
+				if !ok {
+					t.Fatalf("constant %d not a function. got=%T", i, got)
+				}
+				if !bytes.Equal(fn.Instructions, want.Instructions) ||
+					fn.NumLocals != want.NumLocals ||
+					fn.NumParameters != want.NumParameters ||
+					fn.MaxStack != want.MaxStack ||
+					fn.Name != want.Name {
+					t.Errorf("constant %d wrong.\nwant=%+v\ngot =%+v",
+						i, want, fn)
+				}
+				testLineTable(t, debug, want.LineTable, fn.LineTable)
+			}
+		}
+	}
+}
+
+func testLineTable(t *testing.T, debug bool, want, got code.LineTable) {
+	t.Helper()
+
+	if !debug {
+		want = code.LineTable{}
+	}
+	if got.Filename != want.Filename || !bytes.Equal(got.Data, want.Data) {
+		t.Errorf("line table wrong. want=%+v, got=%+v", want, got)
+	}
+}
+
+func TestDecodeRejectsBadInput(t *testing.T) {
+	var buf bytes.Buffer
+	err := Encode(&buf, compile(t, "1 + 2"), true)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	// magic and versions, followed by flags, max stack, instructions...
+	header := Magic + string([]byte{FormatVersion, code.Version})
+
+	corrupted := append([]byte{}, valid...)
+	corrupted[len(corrupted)-6] ^= 0xff
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"empty", nil, "not a monkey bytecode file"},
+		{"source", []byte("let a = 1;"), "not a monkey bytecode file"},
+		{"truncated", valid[:len(valid)-3], "checksum mismatch"},
+		{"corrupted", corrupted, "checksum mismatch"},
+		{"format version", withHeader(valid, FormatVersion+1, code.Version), "unsupported bytecode format version"},
+		{"code version", withHeader(valid, FormatVersion, code.Version+1), "instruction set version"},
+		{"bad constant", sealed([]byte(header + "\x00\x00\x00\x01\x09")), "unknown constant tag 9"},
+		{"long string", sealed([]byte(header + "\x00\x00\x00\x01\x02\x7f")), "exceeds remaining"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.data))
+		if err == nil {
+			t.Errorf("%s: expected error, got none", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: wrong error. want %q in %q",
+				tt.name, tt.expected, err)
+		}
+	}
+}
+
+// withHeader rewrites the versions of an encoded file, which keeps
+// single byte varints in place, and fixes up the checksum
+func withHeader(data []byte, format, version int) []byte {
+	body := append([]byte{}, data[:len(data)-4]...)
+	body[len(Magic)] = byte(format)
+	body[len(Magic)+1] = byte(version)
+	return sealed(body)
+}
+
+func sealed(body []byte) []byte {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(body))
+	return append(body, sum[:]...)
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.NewWithFilename("main.mk", input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
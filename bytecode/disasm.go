@@ -0,0 +1,203 @@
+package bytecode
+
+import (
+	"bufio"
+	"dumch/monkey/code"
+	"dumch/monkey/compiler"
+	"dumch/monkey/object"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Disassemble writes a listing of bc in the text format read by Assemble:
+//
+//	.const
+//	    #0 int 42
+//	    #1 .func "double" params=1 locals=1 stack=2
+//	        0000 OpGetLocal 0
+//	        ...
+//	    .end
+//	.main stack=1
+//	    0000 OpConstant 0 ; 42
+//	    0003 OpJumpNotTruthy L0010
+//	L0010:
+//	    0010 OpNull
+//
+// Jump targets become labels, constant operands are annotated with their
+// values. Malformed bytes are listed as .byte with an error comment.
+func Disassemble(w io.Writer, bc *compiler.Bytecode) error {
+	d := &disassembler{out: bufio.NewWriter(w), constants: bc.Constants}
+
+	fmt.Fprintf(d.out, "; monkey bytecode, instruction set version %d\n",
+		code.Version)
+
+	if len(bc.Constants) > 0 {
+		fmt.Fprintf(d.out, "\n.const\n")
+	}
+	for i, c := range bc.Constants {
+		d.constant(i, c)
+	}
+
+	fmt.Fprintf(d.out, "\n.main stack=%d\n", bc.MaxStack)
+	d.instructions(bc.Instructions, "    ")
+
+	return d.out.Flush()
+}
+
+type disassembler struct {
+	out       *bufio.Writer
+	constants []object.Object
+}
+
+func (d *disassembler) constant(index int, obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		fmt.Fprintf(d.out, "    #%d int %d\n", index, obj.Value)
+	case *object.BigInt:
+		fmt.Fprintf(d.out, "    #%d int %s\n", index, obj.Value)
+	case *object.Float:
+		fmt.Fprintf(d.out, "    #%d float %s\n", index,
+			strconv.FormatFloat(obj.Value, 'g', -1, 64))
+	case *object.String:
+		fmt.Fprintf(d.out, "    #%d string %s\n", index, strconv.Quote(obj.Value))
+	case *object.CompiledFunction:
+		fmt.Fprintf(d.out, "    #%d .func %s params=%d locals=%d stack=%d\n",
+			index, strconv.Quote(obj.Name), obj.NumParameters, obj.NumLocals,
+			obj.MaxStack)
+		d.instructions(obj.Instructions, "        ")
+		fmt.Fprintf(d.out, "    .end\n")
+	default:
+		fmt.Fprintf(d.out, "    ; #%d ERROR: cannot disassemble %s\n",
+			index, obj.Type())
+	}
+}
+
+// instructions lists ins, placing labels before every jump target
+func (d *disassembler) instructions(ins code.Instructions, indent string) {
+	starts, targets := scanInstructions(ins)
+
+	for i := 0; i < len(ins); {
+		if targets[i] {
+			fmt.Fprintf(d.out, "%s:\n", label(i))
+		}
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(d.out, "%s%04d .byte %d ; ERROR: %s\n",
+				indent, i, ins[i], err)
+			i++
+			continue
+		}
+
+		if i+def.Size() > len(ins) {
+			for j := i; j < len(ins); j++ {
+				fmt.Fprintf(d.out, "%s%04d .byte %d ; ERROR: %s operands truncated\n",
+					indent, j, ins[j], def.Name)
+			}
+			return
+		}
+
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		op := code.Opcode(ins[i])
+
+		var line string
+		var comment string
+		if op.IsJump() && starts[operands[0]] {
+			line = def.Name + " " + label(operands[0])
+		} else {
+			line = code.FormatInstruction(def, operands)
+			if op.IsJump() {
+				comment = "ERROR: jump target is not an instruction"
+			} else {
+				comment = d.annotate(op, operands)
+			}
+		}
+
+		if comment != "" {
+			fmt.Fprintf(d.out, "%s%04d %s ; %s\n", indent, i, line, comment)
+		} else {
+			fmt.Fprintf(d.out, "%s%04d %s\n", indent, i, line)
+		}
+
+		i += 1 + read
+	}
+
+	if targets[len(ins)] {
+		fmt.Fprintf(d.out, "%s:\n", label(len(ins)))
+	}
+}
+
+// scanInstructions finds the instruction boundaries of ins, including the
+// end, and the jump targets among them
+func scanInstructions(ins code.Instructions) (starts, targets map[int]bool) {
+	starts = map[int]bool{}
+	targets = map[int]bool{}
+	var jumps []int
+
+	i := 0
+	for i < len(ins) {
+		starts[i] = true
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			i++
+			continue
+		}
+		if i+def.Size() > len(ins) {
+			break // truncated, nothing can follow
+		}
+		if code.Opcode(ins[i]).IsJump() {
+			operands, _ := code.ReadOperands(def, ins[i+1:])
+			jumps = append(jumps, operands[0])
+		}
+		i += def.Size()
+	}
+	if i == len(ins) {
+		starts[len(ins)] = true
+	}
+
+	for _, target := range jumps {
+		if starts[target] {
+			targets[target] = true
+		}
+	}
+	return starts, targets
+}
+
+func label(offset int) string {
+	return fmt.Sprintf("L%04d", offset)
+}
+
+// annotate describes what an operand refers to
+func (d *disassembler) annotate(op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant, code.OpClosure, code.OpConstantWide, code.OpClosureWide:
+		index := operands[0]
+		if index >= len(d.constants) {
+			return fmt.Sprintf("ERROR: constant %d out of range", index)
+		}
+		return describe(d.constants[index])
+	case code.OpGetBuiltin:
+		index := operands[0]
+		if index >= len(object.Builtins) {
+			return fmt.Sprintf("ERROR: builtin %d out of range", index)
+		}
+		return object.Builtins[index].Name
+	}
+	return ""
+}
+
+func describe(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return strconv.Quote(obj.Value)
+	case *object.CompiledFunction:
+		if obj.Name == "" {
+			return "fn"
+		}
+		return "fn " + obj.Name
+	default:
+		return obj.Inspect()
+	}
+}
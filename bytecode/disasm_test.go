@@ -0,0 +1,145 @@
+package bytecode
+
+import (
+	"bytes"
+	"dumch/monkey/code"
+	"dumch/monkey/compiler"
+	"dumch/monkey/object"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let double = fn(x) { x * 2 };
+		if (double(1) > 1) { puts(double(21)) } else { "no" }`
+
+	expected := fmt.Sprintf(`; monkey bytecode, instruction set version %d
+
+.const
+    #0 int 2
+    #1 .func "double" params=1 locals=1 stack=2
+        0000 OpGetLocal 0
+        0002 OpConstant 0 ; 2
+        0005 OpMul
+        0006 OpReturnValue
+    .end
+    #2 int 1
+    #3 int 21
+    #4 string "no"
+
+.main stack=3
+    0000 OpClosure 1 0 ; fn double
+    0004 OpSetGlobal 0
+    0007 OpGetGlobal 0
+    0010 OpConstant 2 ; 1
+    0013 OpCall 1
+    0015 OpConstant 2 ; 1
+    0018 OpGreaterThan
+    0019 OpJumpNotTruthy L0037
+    0022 OpGetBuiltin 1 ; puts
+    0024 OpGetGlobal 0
+    0027 OpConstant 3 ; 21
+    0030 OpCall 1
+    0032 OpCall 1
+    0034 OpJump L0040
+L0037:
+    0037 OpConstant 4 ; "no"
+L0040:
+    0040 OpPop
+`, code.Version)
+
+	var out bytes.Buffer
+	if err := Disassemble(&out, compile(t, input)); err != nil {
+		t.Fatalf("disassemble error: %s", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("listing wrong.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestDisassembleMalformed(t *testing.T) {
+	tests := []struct {
+		name         string
+		instructions code.Instructions
+		expected     []string
+	}{
+		{
+			"unknown opcode",
+			code.Instructions{255, byte(code.OpPop)},
+			[]string{
+				"    0000 .byte 255 ; ERROR: opcode 255 undefined",
+				"    0001 OpPop",
+			},
+		},
+		{
+			"truncated operands",
+			code.Instructions{byte(code.OpPop), byte(code.OpConstant), 0},
+			[]string{
+				"    0000 OpPop",
+				"    0001 .byte 0 ; ERROR: OpConstant operands truncated",
+				"    0002 .byte 0 ; ERROR: OpConstant operands truncated",
+			},
+		},
+		{
+			"jump into operands",
+			concat(code.Make(code.OpJump, 1), code.Make(code.OpPop)),
+			[]string{
+				"    0000 OpJump 1 ; ERROR: jump target is not an instruction",
+				"    0003 OpPop",
+			},
+		},
+		{
+			"jump to end",
+			concat(code.Make(code.OpJump, 3)),
+			[]string{
+				"    0000 OpJump L0003",
+				"L0003:",
+			},
+		},
+		{
+			"wide jump",
+			concat(code.Make(code.OpJumpWide, 5), code.Make(code.OpPop)),
+			[]string{
+				"    0000 OpJumpWide L0005",
+				"L0005:",
+				"    0005 OpPop",
+			},
+		},
+		{
+			"constant out of range",
+			code.Make(code.OpConstant, 7),
+			[]string{
+				"    0000 OpConstant 7 ; ERROR: constant 7 out of range",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		bc := &compiler.Bytecode{
+			Instructions: tt.instructions,
+			Constants:    []object.Object{},
+		}
+
+		var out bytes.Buffer
+		if err := Disassemble(&out, bc); err != nil {
+			t.Fatalf("%s: disassemble error: %s", tt.name, err)
+		}
+
+		_, main, _ := strings.Cut(out.String(), ".main")
+		_, body, _ := strings.Cut(main, "\n")
+		want := strings.Join(tt.expected, "\n") + "\n"
+		if body != want {
+			t.Errorf("%s: listing wrong.\nwant=\n%s\ngot=\n%s", tt.name, want, body)
+		}
+	}
+}
+
+func concat(ins ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, in := range ins {
+		out = append(out, in...)
+	}
+	return out
+}
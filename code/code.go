@@ -4,12 +4,17 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 type Instructions []byte
 
 type Opcode byte
 
+// Version identifies the instruction set. Bump it whenever opcodes or their
+// operands change: serialized bytecode from another version is rejected.
+const Version = 5
+
 const (
 	OpConstant Opcode = iota
 	OpNull
@@ -17,11 +22,16 @@ const (
 	OpHashMap
 
 	OpCall
+	OpTailCall
 	OpReturnValue
 	OpReturn
+	OpClosure
+	OpCurrentClosure
 
 	OpGetLocal
 	OpSetLocal
+	OpGetFree
+	OpGetBuiltin
 
 	OpGetGlobal
 	OpSetGlobal
@@ -44,55 +54,269 @@ const (
 	OpEqual
 	OpNotEqual
 	OpGreaterThan
+	OpLessThan
+	OpLessEqual
+	OpGreaterEqual
 
 	// conditionals
 	OpJumpNotTruthy
 	OpJump
 
 	OpPop
+	OpDup
+
+	// wide variants, emitted when an operand does not fit the narrow one
+	OpConstantWide
+	OpClosureWide
+	OpGetLocalWide
+	OpSetLocalWide
+	OpGetFreeWide
+	OpGetGlobalWide
+	OpSetGlobalWide
+	OpJumpNotTruthyWide
+	OpJumpWide
 )
 
 type Definition struct {
 	Name         string
 	OperandWidth []int
+
+	// Pops and Pushes count the stack values the instruction takes and
+	// leaves. PopsOperand, when set, adds the values counted by an operand.
+	Pops        int
+	Pushes      int
+	PopsOperand func(operands []int) int
 }
 
-var definitions = map[Opcode]*Definition{
-	OpConstant: {"OpConstant", []int{2}},
-	OpNull:     {"OpNull", []int{}},
-	OpArray:    {"OpArray", []int{2}},
-	OpHashMap:  {"OpHash", []int{2}},
+// StackEffect returns how many values the instruction with the given
+// operands pops off the stack and pushes onto it
+func (d *Definition) StackEffect(operands []int) (pops, pushes int) {
+	pops = d.Pops
+	if d.PopsOperand != nil {
+		pops += d.PopsOperand(operands)
+	}
+	return pops, d.Pushes
+}
+
+// operand counts pops by the value of the operand at index
+func operand(index int) func([]int) int {
+	return func(operands []int) int { return operands[index] }
+}
+
+// Size returns the length of the instruction in bytes, opcode included
+func (d *Definition) Size() int {
+	size := 1
+	for _, w := range d.OperandWidth {
+		size += w
+	}
+	return size
+}
 
-	OpCall:        {"OpCall", []int{}},
-	OpReturnValue: {"OpReturnValue", []int{}},
-	OpReturn:      {"OpReturn", []int{}},
+// IsJump reports whether the only operand of op is an instruction offset
+func (op Opcode) IsJump() bool {
+	switch op {
+	case OpJump, OpJumpNotTruthy, OpJumpWide, OpJumpNotTruthyWide:
+		return true
+	}
+	return false
+}
 
-	OpGetLocal: {"OpGetLocal", []int{1}},
-	OpSetLocal: {"OpSetLocal", []int{1}},
+var wideVariants = map[Opcode]Opcode{
+	OpConstant:      OpConstantWide,
+	OpClosure:       OpClosureWide,
+	OpGetLocal:      OpGetLocalWide,
+	OpSetLocal:      OpSetLocalWide,
+	OpGetFree:       OpGetFreeWide,
+	OpGetGlobal:     OpGetGlobalWide,
+	OpSetGlobal:     OpSetGlobalWide,
+	OpJumpNotTruthy: OpJumpNotTruthyWide,
+	OpJump:          OpJumpWide,
+}
 
-	OpGetGlobal: {"OpGetGlobal", []int{2}},
-	OpSetGlobal: {"OpSetGlobal", []int{2}},
+// Wide returns op itself when the operands fit its widths, otherwise its
+// wide variant. ok is false when no variant can hold the operands.
+func Wide(op Opcode, operands ...int) (wide Opcode, ok bool) {
+	if Fits(op, operands...) {
+		return op, true
+	}
+	wide, ok = wideVariants[op]
+	if !ok || !Fits(wide, operands...) {
+		return op, false
+	}
+	return wide, true
+}
 
-	OpIndex: {"OpIndex", []int{}},
+// narrowVariant returns the plain variant of a wide opcode, or op itself
+func narrowVariant(op Opcode) Opcode {
+	for narrow, wide := range wideVariants {
+		if wide == op {
+			return narrow
+		}
+	}
+	return op
+}
 
-	OpAdd: {"OpAdd", []int{}},
-	OpSub: {"OpSub", []int{}},
-	OpMul: {"OpMul", []int{}},
-	OpDiv: {"OpDiv", []int{}},
+// NarrowJumps rewrites the wide jumps of ins whose targets fit the plain
+// variant, moving the code after them back. It returns the new
+// instructions and maps offsets of instruction starts to their new values.
+func NarrowJumps(ins Instructions) (Instructions, func(offset int) int) {
+	type jump struct {
+		offset int
+		size   int
+		target int
+		narrow Opcode
+	}
+	var jumps []jump
+	var narrowed []int // offsets of the jumps made plain
 
-	OpMinus: {"OpMinus", []int{}},
-	OpBang:  {"OpBang", []int{}},
+	for i := 0; i < len(ins); {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			return ins, func(offset int) int { return offset }
+		}
+		op := Opcode(ins[i])
+		if op.IsJump() {
+			operands, _ := ReadOperands(def, ins[i+1:])
+			narrow := narrowVariant(op)
+			if !Fits(narrow, operands...) {
+				narrow = op
+			}
+			if narrow != op {
+				narrowed = append(narrowed, i)
+			}
+			jumps = append(jumps, jump{i, def.Size(), operands[0], narrow})
+		}
+		i += def.Size()
+	}
+	if len(narrowed) == 0 {
+		return ins, func(offset int) int { return offset }
+	}
 
-	OpTrue:        {"OpTrue", []int{}},
-	OpFalse:       {"OpFalse", []int{}},
-	OpEqual:       {"OpEqual", []int{}},
-	OpNotEqual:    {"OpNotEqual", []int{}},
-	OpGreaterThan: {"OpGreaterThan", []int{}},
+	// each narrowed jump drops 2 bytes, offsets after it move back
+	moved := func(offset int) int {
+		return offset - 2*sort.SearchInts(narrowed, offset)
+	}
 
-	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
-	OpJump:          {"OpJump", []int{2}},
+	out := make(Instructions, 0, len(ins)-2*len(narrowed))
+	last := 0
+	for _, j := range jumps {
+		out = append(out, ins[last:j.offset]...)
+		out = append(out, Make(j.narrow, moved(j.target))...)
+		last = j.offset + j.size
+	}
+	out = append(out, ins[last:]...)
 
-	OpPop: {"OpPop", []int{}},
+	return out, moved
+}
+
+// MarkTailCalls turns the calls of ins whose result is returned right away,
+// possibly after unconditional jumps, into OpTailCall. ins is changed in
+// place.
+func MarkTailCalls(ins Instructions) {
+	for i := 0; i < len(ins); {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			return
+		}
+		if Opcode(ins[i]) == OpCall && returnsAt(ins, i+def.Size()) {
+			ins[i] = byte(OpTailCall)
+		}
+		i += def.Size()
+	}
+}
+
+// returnsAt reports whether execution from offset goes straight to an
+// OpReturnValue
+func returnsAt(ins Instructions, offset int) bool {
+	// a jump may only be taken once, more means a loop
+	for jumps := 0; offset < len(ins) && jumps <= len(ins); jumps++ {
+		switch Opcode(ins[offset]) {
+		case OpReturnValue:
+			return true
+		case OpJump:
+			offset = int(ReadUint16(ins[offset+1:]))
+		case OpJumpWide:
+			offset = int(ReadUint32(ins[offset+1:]))
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// Fits reports whether every operand is within the width defined for it
+func Fits(op Opcode, operands ...int) bool {
+	def, ok := definitions[op]
+	if !ok {
+		return false
+	}
+	for i, o := range operands {
+		if i >= len(def.OperandWidth) || o < 0 || o >= 1<<(8*def.OperandWidth[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}, 0, 1, nil},
+	OpNull:     {"OpNull", []int{}, 0, 1, nil},
+	OpArray:    {"OpArray", []int{2}, 0, 1, operand(0)},
+	OpHashMap:  {"OpHash", []int{2}, 0, 1, operand(0)},
+
+	// the callee and its arguments are replaced by the result
+	OpCall: {"OpCall", []int{1}, 1, 1, operand(0)},
+	// a call whose result is returned, a closure reuses the current frame
+	OpTailCall:    {"OpTailCall", []int{1}, 1, 1, operand(0)},
+	OpReturnValue: {"OpReturnValue", []int{}, 1, 0, nil},
+	OpReturn:      {"OpReturn", []int{}, 0, 0, nil},
+	// constant index of the function, number of free variables on the stack
+	OpClosure:        {"OpClosure", []int{2, 1}, 0, 1, operand(1)},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}, 0, 1, nil},
+
+	OpGetLocal: {"OpGetLocal", []int{1}, 0, 1, nil},
+	OpSetLocal: {"OpSetLocal", []int{1}, 1, 0, nil},
+	OpGetFree:  {"OpGetFree", []int{1}, 0, 1, nil},
+
+	OpGetBuiltin: {"OpGetBuiltin", []int{1}, 0, 1, nil},
+
+	OpGetGlobal: {"OpGetGlobal", []int{2}, 0, 1, nil},
+	OpSetGlobal: {"OpSetGlobal", []int{2}, 1, 0, nil},
+
+	OpIndex: {"OpIndex", []int{}, 2, 1, nil},
+
+	OpAdd: {"OpAdd", []int{}, 2, 1, nil},
+	OpSub: {"OpSub", []int{}, 2, 1, nil},
+	OpMul: {"OpMul", []int{}, 2, 1, nil},
+	OpDiv: {"OpDiv", []int{}, 2, 1, nil},
+
+	OpMinus: {"OpMinus", []int{}, 1, 1, nil},
+	OpBang:  {"OpBang", []int{}, 1, 1, nil},
+
+	OpTrue:         {"OpTrue", []int{}, 0, 1, nil},
+	OpFalse:        {"OpFalse", []int{}, 0, 1, nil},
+	OpEqual:        {"OpEqual", []int{}, 2, 1, nil},
+	OpNotEqual:     {"OpNotEqual", []int{}, 2, 1, nil},
+	OpGreaterThan:  {"OpGreaterThan", []int{}, 2, 1, nil},
+	OpLessThan:     {"OpLessThan", []int{}, 2, 1, nil},
+	OpLessEqual:    {"OpLessEqual", []int{}, 2, 1, nil},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}, 2, 1, nil},
+
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}, 1, 0, nil},
+	OpJump:          {"OpJump", []int{2}, 0, 0, nil},
+
+	OpPop: {"OpPop", []int{}, 1, 0, nil},
+	OpDup: {"OpDup", []int{}, 1, 2, nil},
+
+	OpConstantWide:      {"OpConstantWide", []int{4}, 0, 1, nil},
+	OpClosureWide:       {"OpClosureWide", []int{4, 2}, 0, 1, operand(1)},
+	OpGetLocalWide:      {"OpGetLocalWide", []int{2}, 0, 1, nil},
+	OpSetLocalWide:      {"OpSetLocalWide", []int{2}, 1, 0, nil},
+	OpGetFreeWide:       {"OpGetFreeWide", []int{2}, 0, 1, nil},
+	OpGetGlobalWide:     {"OpGetGlobalWide", []int{4}, 0, 1, nil},
+	OpSetGlobalWide:     {"OpSetGlobalWide", []int{4}, 1, 0, nil},
+	OpJumpNotTruthyWide: {"OpJumpNotTruthyWide", []int{4}, 1, 0, nil},
+	OpJumpWide:          {"OpJumpWide", []int{4}, 0, 0, nil},
 }
 
 func (ins Instructions) String() string {
@@ -102,13 +326,19 @@ func (ins Instructions) String() string {
 	for i < len(ins) {
 		def, err := Lookup(ins[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			i++ // skip the unknown byte and go on
 			continue
 		}
 
+		if i+def.Size() > len(ins) {
+			fmt.Fprintf(&out, "%04d ERROR: %s operands truncated\n", i, def.Name)
+			break
+		}
+
 		operands, read := ReadOperands(def, ins[i+1:])
 
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		fmt.Fprintf(&out, "%04d %s\n", i, FormatInstruction(def, operands))
 
 		i++       // Definition name, like OpConstant
 		i += read // Operands bytes
@@ -117,7 +347,8 @@ func (ins Instructions) String() string {
 	return out.String()
 }
 
-func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+// FormatInstruction renders the name followed by the operands
+func FormatInstruction(def *Definition, operands []int) string {
 	operandCount := len(def.OperandWidth)
 
 	if len(operands) != operandCount {
@@ -125,14 +356,12 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 			len(operands), operandCount)
 	}
 
-	switch operandCount {
-	case 0:
-		return def.Name
-	case 1:
-		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	var out bytes.Buffer
+	out.WriteString(def.Name)
+	for _, o := range operands {
+		fmt.Fprintf(&out, " %d", o)
 	}
-
-	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+	return out.String()
 }
 
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
@@ -145,6 +374,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 			operands[i] = int(ReadUint8(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		}
 
 		offset += width
@@ -161,6 +392,10 @@ func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
 
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func Lookup(op byte) (*Definition, error) {
 	def, ok := definitions[Opcode(op)]
 	if !ok {
@@ -169,6 +404,16 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// LookupName finds an opcode by the name of its definition
+func LookupName(name string) (Opcode, *Definition, error) {
+	for op, def := range definitions {
+		if def.Name == name {
+			return op, def, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("opcode %s undefined", name)
+}
+
 // Make takes Opcode and operands, returns bytes in Big-Endian
 // Example input: `OpConstant`, 1
 // Example output: `[0 0 1]` (OpConstant is 0, 01 is two bytes encoding 1)
@@ -178,18 +423,15 @@ func Make(op Opcode, operands ...int) []byte {
 		return []byte{}
 	}
 
-	instructionLen := 1
-	for _, w := range def.OperandWidth {
-		instructionLen += w
-	}
-
-	instruction := make([]byte, instructionLen)
+	instruction := make([]byte, def.Size())
 	instruction[0] = byte(op)
 
 	offset := 1
 	for i, o := range operands {
 		width := def.OperandWidth[i]
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
 		case 1:
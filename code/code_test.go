@@ -1,6 +1,9 @@
 package code
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -11,6 +14,9 @@ func TestMake(t *testing.T) {
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpConstantWide, []int{65536}, []byte{byte(OpConstantWide), 0, 1, 0, 0}},
+		{OpClosureWide, []int{65536, 256}, []byte{byte(OpClosureWide), 0, 1, 0, 0, 1, 0}},
 	}
 
 	for _, tt := range tests {
@@ -36,12 +42,14 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpGetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
 	}
 
 	expected := `0000 OpAdd
 0001 OpGetLocal 1
 0003 OpConstant 2
 0006 OpConstant 65535
+0009 OpClosure 65535 255
 `
 
 	concatted := Instructions{}
@@ -57,6 +65,23 @@ func TestInstructionsString(t *testing.T) {
 	}
 }
 
+func TestMalformedInstructionsString(t *testing.T) {
+	instructions := Instructions{255}
+	instructions = append(instructions, Make(OpAdd)...)
+	instructions = append(instructions, Make(OpConstant, 1)[:2]...)
+
+	expected := `0000 ERROR: opcode 255 undefined
+0001 OpAdd
+0002 ERROR: OpConstant operands truncated
+`
+
+	got := instructions.String()
+	if got != expected {
+		t.Errorf("instructions wrongly formatted.\ngot=%q\nwant=%q",
+			got, expected)
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -65,6 +90,10 @@ func TestReadOperands(t *testing.T) {
 	}{
 		{OpConstant, []int{65535}, 2},
 		{OpGetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
+		{OpConstantWide, []int{1<<32 - 1}, 4},
+		{OpGetLocalWide, []int{65535}, 2},
+		{OpClosureWide, []int{70000, 300}, 6},
 	}
 
 	for _, tt := range tests {
@@ -87,3 +116,142 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestLookupName(t *testing.T) {
+	for op, def := range definitions {
+		got, gotDef, err := LookupName(def.Name)
+		if err != nil {
+			t.Fatalf("lookup %s failed: %s", def.Name, err)
+		}
+		if got != op || gotDef != def {
+			t.Errorf("lookup %s wrong. want=%d, got=%d", def.Name, op, got)
+		}
+	}
+
+	if _, _, err := LookupName("OpNope"); err == nil {
+		t.Errorf("expected an error for an unknown name")
+	}
+}
+
+func TestWide(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected Opcode
+		ok       bool
+	}{
+		{OpConstant, []int{65535}, OpConstant, true},
+		{OpConstant, []int{65536}, OpConstantWide, true},
+		{OpGetLocal, []int{255}, OpGetLocal, true},
+		{OpGetLocal, []int{256}, OpGetLocalWide, true},
+		{OpSetLocal, []int{256}, OpSetLocalWide, true},
+		{OpGetFree, []int{256}, OpGetFreeWide, true},
+		{OpGetGlobal, []int{65536}, OpGetGlobalWide, true},
+		{OpClosure, []int{1, 256}, OpClosureWide, true},
+		{OpClosure, []int{65536, 0}, OpClosureWide, true},
+		{OpJump, []int{65536}, OpJumpWide, true},
+		{OpGetLocal, []int{65536}, OpGetLocal, false},
+		{OpCall, []int{256}, OpCall, false},
+	}
+
+	for _, tt := range tests {
+		op, ok := Wide(tt.op, tt.operands...)
+		if op != tt.expected || ok != tt.ok {
+			t.Errorf("Wide(%s, %v) wrong. want=%s %t, got=%s %t",
+				definitions[tt.op].Name, tt.operands,
+				definitions[tt.expected].Name, tt.ok, definitions[op].Name, ok)
+		}
+	}
+}
+
+func TestNarrowJumps(t *testing.T) {
+	// if (true) { 1 } else { 2 } with wide jumps, as the compiler emits it
+	wide := concat(
+		Make(OpTrue),
+		Make(OpJumpNotTruthyWide, 14),
+		Make(OpConstant, 0),
+		Make(OpJumpWide, 17),
+		Make(OpConstant, 1),
+		Make(OpPop),
+	)
+	expected := concat(
+		Make(OpTrue),
+		Make(OpJumpNotTruthy, 10),
+		Make(OpConstant, 0),
+		Make(OpJump, 13),
+		Make(OpConstant, 1),
+		Make(OpPop),
+	)
+
+	narrowed, moved := NarrowJumps(wide)
+	if !bytes.Equal(narrowed, expected) {
+		t.Errorf("wrong instructions.\nwant=%s\ngot =%s", expected, narrowed)
+	}
+
+	offsets := map[int]int{0: 0, 1: 1, 6: 4, 9: 7, 14: 10, 17: 13, 18: 14}
+	for old, want := range offsets {
+		if got := moved(old); got != want {
+			t.Errorf("offset %d moved wrong. want=%d, got=%d", old, want, got)
+		}
+	}
+
+	// a target past 65535 keeps the jump wide
+	far := concat(Make(OpJumpWide, 70005), make([]byte, 70000), Make(OpPop))
+	for i := 5; i < 70005; i++ {
+		far[i] = byte(OpNull)
+	}
+	kept, _ := NarrowJumps(far)
+	if !bytes.Equal(kept, far) {
+		t.Errorf("far jump was narrowed")
+	}
+}
+
+func TestMarkTailCalls(t *testing.T) {
+	tests := []struct {
+		input    Instructions
+		expected Instructions
+	}{
+		{
+			concat(Make(OpGetLocal, 0), Make(OpCall, 0), Make(OpReturnValue)),
+			concat(Make(OpGetLocal, 0), Make(OpTailCall, 0), Make(OpReturnValue)),
+		},
+		{
+			// the call of the consequence jumps to the return
+			concat(
+				Make(OpGetLocal, 0),
+				Make(OpJumpNotTruthy, 12),
+				Make(OpGetLocal, 0),
+				Make(OpCall, 0),
+				Make(OpJump, 13),
+				Make(OpNull),
+				Make(OpReturnValue),
+			),
+			concat(
+				Make(OpGetLocal, 0),
+				Make(OpJumpNotTruthy, 12),
+				Make(OpGetLocal, 0),
+				Make(OpTailCall, 0),
+				Make(OpJump, 13),
+				Make(OpNull),
+				Make(OpReturnValue),
+			),
+		},
+		{
+			concat(Make(OpGetLocal, 0), Make(OpCall, 0), Make(OpPop), Make(OpReturn)),
+			concat(Make(OpGetLocal, 0), Make(OpCall, 0), Make(OpPop), Make(OpReturn)),
+		},
+		{
+			// a jump to itself does not hang
+			concat(Make(OpGetLocal, 0), Make(OpCall, 0), Make(OpJump, 4)),
+			concat(Make(OpGetLocal, 0), Make(OpCall, 0), Make(OpJump, 4)),
+		},
+	}
+
+	for _, tt := range tests {
+		ins := append(Instructions{}, tt.input...)
+		MarkTailCalls(ins)
+		if !bytes.Equal(ins, tt.expected) {
+			t.Errorf("wrong instructions.\nwant=%s\ngot =%s", tt.expected, ins)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package code
+
+import (
+	"dumch/monkey/token"
+	"encoding/binary"
+)
+
+// SourcePosition maps the instruction starting at Offset
+// to the source position it was compiled from
+type SourcePosition struct {
+	Offset int
+	Pos    token.Position
+}
+
+// LineTable is a compact offset-to-source-position table.
+// Every entry is stored as four varints relative to the previous entry:
+// instruction offset delta, source offset delta, line delta and column.
+// An entry covers all instructions up to the next one.
+type LineTable struct {
+	Filename string
+	Data     []byte
+}
+
+// NewLineTable encodes positions, which must be sorted by Offset
+func NewLineTable(positions []SourcePosition) LineTable {
+	var lt LineTable
+	if len(positions) == 0 {
+		return lt
+	}
+	lt.Filename = positions[0].Pos.Filename
+
+	var prev SourcePosition
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, p := range positions {
+		n := binary.PutUvarint(buf, uint64(p.Offset-prev.Offset))
+		lt.Data = append(lt.Data, buf[:n]...)
+		n = binary.PutVarint(buf, int64(p.Pos.Offset-prev.Pos.Offset))
+		lt.Data = append(lt.Data, buf[:n]...)
+		n = binary.PutVarint(buf, int64(p.Pos.Line-prev.Pos.Line))
+		lt.Data = append(lt.Data, buf[:n]...)
+		n = binary.PutUvarint(buf, uint64(p.Pos.Column))
+		lt.Data = append(lt.Data, buf[:n]...)
+		prev = p
+	}
+	return lt
+}
+
+// Entries decodes the table. A malformed table decodes up to the broken entry.
+func (lt LineTable) Entries() []SourcePosition {
+	var entries []SourcePosition
+	var prev SourcePosition
+
+	data := lt.Data
+	for len(data) > 0 {
+		var fields [4]int64
+		for i := range fields {
+			var n int
+			if i == 0 || i == 3 {
+				var v uint64
+				v, n = binary.Uvarint(data)
+				fields[i] = int64(v)
+			} else {
+				fields[i], n = binary.Varint(data)
+			}
+			if n <= 0 {
+				return entries
+			}
+			data = data[n:]
+		}
+
+		entry := SourcePosition{
+			Offset: prev.Offset + int(fields[0]),
+			Pos: token.Position{
+				Filename: lt.Filename,
+				Offset:   prev.Pos.Offset + int(fields[1]),
+				Line:     prev.Pos.Line + int(fields[2]),
+				Column:   int(fields[3]),
+			},
+		}
+		entries = append(entries, entry)
+		prev = entry
+	}
+	return entries
+}
+
+// Relative returns pos as a position in the source that starts at origin:
+// lines count from 1 at origin's line, columns on that line from 1 at
+// origin's column. Function line tables are relative to where the literal
+// starts, so the same function compiled elsewhere has the same table.
+func Relative(pos, origin token.Position) token.Position {
+	if !pos.IsValid() {
+		return token.Position{}
+	}
+	rel := token.Position{
+		Offset: pos.Offset - origin.Offset,
+		Line:   pos.Line - origin.Line + 1,
+		Column: pos.Column,
+	}
+	if pos.Line == origin.Line {
+		rel.Column = pos.Column - origin.Column + 1
+	}
+	return rel
+}
+
+// Resolve turns a position made by Relative back into an absolute one
+func Resolve(rel, origin token.Position) token.Position {
+	if !rel.IsValid() || !origin.IsValid() {
+		return token.Position{}
+	}
+	pos := token.Position{
+		Filename: origin.Filename,
+		Offset:   origin.Offset + rel.Offset,
+		Line:     origin.Line + rel.Line - 1,
+		Column:   rel.Column,
+	}
+	if rel.Line == 1 {
+		pos.Column = origin.Column + rel.Column - 1
+	}
+	return pos
+}
+
+// PositionAt finds the source position of the instruction containing offset
+func (lt LineTable) PositionAt(offset int) token.Position {
+	var pos token.Position
+	for _, e := range lt.Entries() {
+		if e.Offset > offset {
+			break
+		}
+		pos = e.Pos
+	}
+	return pos
+}
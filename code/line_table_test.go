@@ -0,0 +1,93 @@
+package code
+
+import (
+	"dumch/monkey/token"
+	"testing"
+)
+
+func TestLineTableRoundTrip(t *testing.T) {
+	positions := []SourcePosition{
+		{0, token.Position{Filename: "a.mk", Offset: 8, Line: 1, Column: 9}},
+		{3, token.Position{Filename: "a.mk", Offset: 0, Line: 1, Column: 1}},
+		{6, token.Position{Filename: "a.mk", Offset: 300, Line: 42, Column: 3}},
+		{70000, token.Position{Filename: "a.mk", Offset: 12, Line: 2, Column: 130}},
+	}
+
+	lt := NewLineTable(positions)
+	if lt.Filename != "a.mk" {
+		t.Errorf("wrong filename. got=%q", lt.Filename)
+	}
+
+	entries := lt.Entries()
+	if len(entries) != len(positions) {
+		t.Fatalf("wrong number of entries. got=%d, want=%d",
+			len(entries), len(positions))
+	}
+
+	for i, want := range positions {
+		if entries[i] != want {
+			t.Errorf("entry %d wrong. got=%+v, want=%+v", i, entries[i], want)
+		}
+	}
+}
+
+func TestLineTablePositionAt(t *testing.T) {
+	lt := NewLineTable([]SourcePosition{
+		{0, token.Position{Line: 1, Column: 1}},
+		{3, token.Position{Line: 2, Column: 5}},
+		{7, token.Position{Line: 4, Column: 2}},
+	})
+
+	tests := []struct {
+		offset   int
+		expected string
+	}{
+		{-1, "-"},
+		{0, "1:1"},
+		{2, "1:1"},
+		{3, "2:5"},
+		{6, "2:5"},
+		{7, "4:2"},
+		{100, "4:2"},
+	}
+
+	for _, tt := range tests {
+		pos := lt.PositionAt(tt.offset)
+		if pos.String() != tt.expected {
+			t.Errorf("wrong position at %d. got=%s, want=%s",
+				tt.offset, pos, tt.expected)
+		}
+	}
+
+	if len(lt.Data) > 3*4 {
+		t.Errorf("table is not compact. got %d bytes", len(lt.Data))
+	}
+}
+
+func TestRelativePositions(t *testing.T) {
+	origin := token.Position{Filename: "a.mk", Offset: 20, Line: 3, Column: 9}
+
+	tests := []struct {
+		pos      token.Position
+		relative string
+	}{
+		{token.Position{Filename: "a.mk", Offset: 20, Line: 3, Column: 9}, "1:1"},
+		{token.Position{Filename: "a.mk", Offset: 26, Line: 3, Column: 15}, "1:7"},
+		{token.Position{Filename: "a.mk", Offset: 40, Line: 5, Column: 3}, "3:3"},
+	}
+
+	for _, tt := range tests {
+		rel := Relative(tt.pos, origin)
+		if rel.String() != tt.relative {
+			t.Errorf("wrong relative position of %s. got=%s, want=%s",
+				tt.pos, rel, tt.relative)
+		}
+		if back := Resolve(rel, origin); back != tt.pos {
+			t.Errorf("wrong resolved position. got=%+v, want=%+v", back, tt.pos)
+		}
+	}
+
+	if pos := Resolve(token.Position{Line: 1, Column: 1}, token.Position{}); pos.IsValid() {
+		t.Errorf("resolved without an origin to %s", pos)
+	}
+}
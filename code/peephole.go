@@ -0,0 +1,149 @@
+package code
+
+// Optimize runs a peephole pass over ins until none of its rewrites apply:
+//
+//	OpJump to the next instruction          removed
+//	OpTrue; OpJumpNotTruthy                 removed
+//	OpSetGlobal x; OpGetGlobal x            OpDup; OpSetGlobal x
+//	a push without side effects; OpPop      removed
+//
+// A sequence is only rewritten when no jump lands inside it. The final
+// OpPop of ins stays, it leaves the value a REPL shows. Optimize returns the
+// new instructions and maps offsets of instruction starts to their new
+// values, removed instructions map to the one that took their place.
+func Optimize(ins Instructions) (Instructions, func(offset int) int) {
+	same := func(offset int) int { return offset }
+
+	type instruction struct {
+		op       Opcode
+		operands []int
+		removed  bool
+	}
+	var code []*instruction
+	index := map[int]int{} // offset → index in code
+
+	for i := 0; i < len(ins); {
+		def, err := Lookup(ins[i])
+		if err != nil || i+def.Size() > len(ins) {
+			return ins, same
+		}
+		operands, _ := ReadOperands(def, ins[i+1:])
+		index[i] = len(code)
+		code = append(code, &instruction{Opcode(ins[i]), operands, false})
+		i += def.Size()
+	}
+	index[len(ins)] = len(code)
+
+	// jump targets become indexes into code while rewriting
+	for _, in := range code {
+		if in.op.IsJump() {
+			target, ok := index[in.operands[0]]
+			if !ok {
+				return ins, same
+			}
+			in.operands = []int{target}
+		}
+	}
+
+	// next returns the index of the first instruction left from i on
+	next := func(i int) int {
+		for i < len(code) && code[i].removed {
+			i++
+		}
+		return i
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		targets := map[int]bool{}
+		for _, in := range code {
+			if !in.removed && in.op.IsJump() {
+				in.operands[0] = next(in.operands[0])
+				targets[in.operands[0]] = true
+			}
+		}
+
+		// jumps to instructions removed in this round land on the next one
+		jumpedInto := func(i, j int) bool {
+			for k := i + 1; k <= j; k++ {
+				if targets[k] {
+					return true
+				}
+			}
+			return false
+		}
+
+		for i := next(0); i < len(code); i = next(i + 1) {
+			in := code[i]
+			j := next(i + 1)
+
+			if (in.op == OpJump || in.op == OpJumpWide) && next(in.operands[0]) == j {
+				in.removed = true
+				changed = true
+				continue
+			}
+			if j == len(code) || jumpedInto(i, j) {
+				continue
+			}
+			after := code[j]
+
+			switch {
+			case in.op == OpTrue && (after.op == OpJumpNotTruthy || after.op == OpJumpNotTruthyWide):
+				in.removed, after.removed = true, true
+			case isSetGlobal(in.op) && isGetGlobal(after.op) && in.operands[0] == after.operands[0]:
+				*in, *after = instruction{op: OpDup}, *in
+			case isPurePush(in.op) && after.op == OpPop && next(j+1) < len(code):
+				in.removed, after.removed = true, true
+			default:
+				continue
+			}
+			changed = true
+		}
+	}
+
+	newOffsets := make([]int, len(code)+1)
+	offset := 0
+	for i, in := range code {
+		newOffsets[i] = offset
+		if !in.removed {
+			offset += definitions[in.op].Size()
+		}
+	}
+	newOffsets[len(code)] = offset
+
+	out := make(Instructions, 0, offset)
+	for _, in := range code {
+		if in.removed {
+			continue
+		}
+		operands := in.operands
+		if in.op.IsJump() {
+			operands = []int{newOffsets[in.operands[0]]}
+		}
+		out = append(out, Make(in.op, operands...)...)
+	}
+
+	moved := func(offset int) int {
+		if i, ok := index[offset]; ok {
+			return newOffsets[i]
+		}
+		return offset
+	}
+	return out, moved
+}
+
+func isSetGlobal(op Opcode) bool { return op == OpSetGlobal || op == OpSetGlobalWide }
+func isGetGlobal(op Opcode) bool { return op == OpGetGlobal || op == OpGetGlobalWide }
+
+// isPurePush reports whether op only pushes a value, so dropping it along
+// with the OpPop after it changes nothing
+func isPurePush(op Opcode) bool {
+	switch op {
+	case OpConstant, OpConstantWide, OpNull, OpTrue, OpFalse,
+		OpGetLocal, OpGetLocalWide, OpGetFree, OpGetFreeWide, OpGetBuiltin,
+		OpGetGlobal, OpGetGlobalWide, OpCurrentClosure:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package code
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    Instructions
+		expected Instructions
+	}{
+		{
+			"jump to the next instruction",
+			concat(Make(OpJump, 3), Make(OpNull), Make(OpPop)),
+			concat(Make(OpNull), Make(OpPop)),
+		},
+		{
+			"true condition",
+			// if (true) { 1 } else { 2 }
+			concat(
+				Make(OpTrue),
+				Make(OpJumpNotTruthy, 10),
+				Make(OpConstant, 0),
+				Make(OpJump, 13),
+				Make(OpConstant, 1),
+				Make(OpPop),
+			),
+			concat(
+				Make(OpConstant, 0),
+				Make(OpJump, 9),
+				Make(OpConstant, 1),
+				Make(OpPop),
+			),
+		},
+		{
+			"global read back",
+			concat(
+				Make(OpConstant, 0),
+				Make(OpSetGlobal, 1),
+				Make(OpGetGlobal, 1),
+				Make(OpSetGlobal, 2),
+				Make(OpGetGlobal, 1),
+				Make(OpPop),
+			),
+			concat(
+				Make(OpConstant, 0),
+				Make(OpDup),
+				Make(OpSetGlobal, 1),
+				Make(OpSetGlobal, 2),
+				Make(OpGetGlobal, 1),
+				Make(OpPop),
+			),
+		},
+		{
+			"value popped right away",
+			concat(
+				Make(OpConstant, 0),
+				Make(OpPop),
+				Make(OpGetLocal, 0),
+				Make(OpPop),
+				Make(OpNull),
+				Make(OpPop),
+			),
+			concat(Make(OpNull), Make(OpPop)),
+		},
+		{
+			"pop after a call stays",
+			concat(Make(OpGetBuiltin, 0), Make(OpCall, 0), Make(OpPop), Make(OpNull), Make(OpPop)),
+			concat(Make(OpGetBuiltin, 0), Make(OpCall, 0), Make(OpPop), Make(OpNull), Make(OpPop)),
+		},
+		{
+			"jump into a sequence",
+			concat(
+				Make(OpGetGlobal, 0),
+				Make(OpJumpNotTruthy, 7),
+				Make(OpNull),
+				Make(OpPop),
+				Make(OpNull),
+				Make(OpPop),
+			),
+			concat(
+				Make(OpGetGlobal, 0),
+				Make(OpJumpNotTruthy, 7),
+				Make(OpNull),
+				Make(OpPop),
+				Make(OpNull),
+				Make(OpPop),
+			),
+		},
+		{
+			"rewrites making room for more",
+			// the jump reaches the next instruction once the pair is gone
+			concat(
+				Make(OpJumpWide, 11),
+				Make(OpTrue),
+				Make(OpJumpNotTruthyWide, 11),
+				Make(OpNull),
+				Make(OpPop),
+			),
+			concat(Make(OpNull), Make(OpPop)),
+		},
+	}
+
+	for _, tt := range tests {
+		optimized, _ := Optimize(tt.input)
+		if !bytes.Equal(optimized, tt.expected) {
+			t.Errorf("%s: wrong instructions.\nwant=%s\ngot =%s",
+				tt.name, tt.expected, optimized)
+		}
+	}
+}
+
+func TestOptimizeMovesOffsets(t *testing.T) {
+	// if (true) { 1 } else { 2 }
+	ins := concat(
+		Make(OpTrue),
+		Make(OpJumpNotTruthy, 10),
+		Make(OpConstant, 0),
+		Make(OpJump, 13),
+		Make(OpConstant, 1),
+		Make(OpPop),
+	)
+
+	_, moved := Optimize(ins)
+
+	// removed instructions move to the one after them
+	offsets := map[int]int{0: 0, 1: 0, 4: 0, 7: 3, 10: 6, 13: 9, 14: 10}
+	for old, want := range offsets {
+		if got := moved(old); got != want {
+			t.Errorf("offset %d moved wrong. want=%d, got=%d", old, want, got)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package code
+
+import "fmt"
+
+// StackError reports the instruction at which the stack depth goes wrong
+type StackError struct {
+	Offset  int
+	Message string
+}
+
+func (e *StackError) Error() string {
+	return fmt.Sprintf("%04d: %s", e.Offset, e.Message)
+}
+
+// StackDepths follows every path through ins and returns the stack depth
+// before each reachable instruction, keyed by offset. The end of ins is
+// included when execution can run off it. Paths meeting with different
+// depths and pops from a too shallow stack are errors.
+func StackDepths(ins Instructions) (map[int]int, error) {
+	depths := map[int]int{0: 0}
+	work := []int{0}
+
+	for len(work) > 0 {
+		offset := work[len(work)-1]
+		work = work[:len(work)-1]
+		if offset == len(ins) {
+			continue
+		}
+		if offset > len(ins) {
+			return nil, &StackError{offset, "offset past the end of the instructions"}
+		}
+
+		def, err := Lookup(ins[offset])
+		if err != nil {
+			return nil, &StackError{offset, err.Error()}
+		}
+		if offset+def.Size() > len(ins) {
+			return nil, &StackError{offset, def.Name + " operands truncated"}
+		}
+		op := Opcode(ins[offset])
+		operands, _ := ReadOperands(def, ins[offset+1:])
+
+		depth := depths[offset]
+		pops, pushes := def.StackEffect(operands)
+		if depth < pops {
+			return nil, &StackError{offset,
+				fmt.Sprintf("%s needs %d values, stack has %d", def.Name, pops, depth)}
+		}
+		depth += pushes - pops
+
+		var next []int
+		switch op {
+		case OpReturn, OpReturnValue:
+		case OpJump, OpJumpWide:
+			next = []int{operands[0]}
+		case OpJumpNotTruthy, OpJumpNotTruthyWide:
+			next = []int{offset + def.Size(), operands[0]}
+		default:
+			next = []int{offset + def.Size()}
+		}
+
+		for _, n := range next {
+			seen, ok := depths[n]
+			if !ok {
+				depths[n] = depth
+				work = append(work, n)
+			} else if seen != depth {
+				return nil, &StackError{n,
+					fmt.Sprintf("stack depth %d on one path and %d on another", seen, depth)}
+			}
+		}
+	}
+	return depths, nil
+}
+
+// MaxStackDepth returns the deepest the stack gets while running ins
+func MaxStackDepth(ins Instructions) (int, error) {
+	depths, err := StackDepths(ins)
+	if err != nil {
+		return 0, err
+	}
+
+	max := 0
+	for _, depth := range depths {
+		if depth > max {
+			max = depth
+		}
+	}
+	return max, nil
+}
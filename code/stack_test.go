@@ -0,0 +1,117 @@
+package code
+
+import "testing"
+
+func TestStackEffect(t *testing.T) {
+	tests := []struct {
+		op             Opcode
+		operands       []int
+		expectedPops   int
+		expectedPushes int
+	}{
+		{OpConstant, []int{0}, 0, 1},
+		{OpAdd, []int{}, 2, 1},
+		{OpPop, []int{}, 1, 0},
+		{OpArray, []int{3}, 3, 1},
+		{OpHashMap, []int{4}, 4, 1},
+		{OpCall, []int{2}, 3, 1},
+		{OpClosure, []int{7, 2}, 2, 1},
+		{OpJumpNotTruthy, []int{10}, 1, 0},
+		{OpReturnValue, []int{}, 1, 0},
+	}
+
+	for _, tt := range tests {
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+
+		pops, pushes := def.StackEffect(tt.operands)
+		if pops != tt.expectedPops || pushes != tt.expectedPushes {
+			t.Errorf("%s %v: wrong effect. want=-%d+%d, got=-%d+%d", def.Name,
+				tt.operands, tt.expectedPops, tt.expectedPushes, pops, pushes)
+		}
+	}
+}
+
+func TestMaxStackDepth(t *testing.T) {
+	tests := []struct {
+		instructions []byte
+		expected     int
+	}{
+		{[]byte{}, 0},
+		{concat(Make(OpConstant, 0), Make(OpPop)), 1},
+		{concat(
+			Make(OpConstant, 0),
+			Make(OpConstant, 1),
+			Make(OpConstant, 2),
+			Make(OpArray, 3),
+			Make(OpPop),
+		), 3},
+		// if (true) { 1 + 2 } else { 3 }
+		{concat(
+			Make(OpTrue),
+			Make(OpJumpNotTruthy, 14),
+			Make(OpConstant, 0),
+			Make(OpConstant, 1),
+			Make(OpAdd),
+			Make(OpJump, 17),
+			Make(OpConstant, 2),
+			Make(OpPop),
+		), 2},
+		// code after a return is not reached
+		{concat(
+			Make(OpNull),
+			Make(OpReturnValue),
+			Make(OpConstant, 0),
+			Make(OpConstant, 0),
+			Make(OpConstant, 0),
+		), 1},
+	}
+
+	for _, tt := range tests {
+		depth, err := MaxStackDepth(tt.instructions)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if depth != tt.expected {
+			t.Errorf("wrong depth for\n%s\nwant=%d, got=%d",
+				Instructions(tt.instructions), tt.expected, depth)
+		}
+	}
+}
+
+func TestStackDepthsErrors(t *testing.T) {
+	tests := []struct {
+		instructions []byte
+		expected     string
+	}{
+		{Make(OpAdd), "0000: OpAdd needs 2 values, stack has 0"},
+		{concat(
+			Make(OpTrue),
+			Make(OpTrue),
+			Make(OpJumpNotTruthy, 6),
+			Make(OpTrue),
+		), "0006: stack depth 1 on one path and 2 on another"},
+		{Make(OpJump, 9), "0009: offset past the end of the instructions"},
+	}
+
+	for _, tt := range tests {
+		_, err := StackDepths(tt.instructions)
+		if err == nil {
+			t.Errorf("expected error %q", tt.expected)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func concat(ins ...[]byte) Instructions {
+	out := Instructions{}
+	for _, in := range ins {
+		out = append(out, in...)
+	}
+	return out
+}
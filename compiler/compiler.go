@@ -4,8 +4,11 @@ import (
 	"dumch/monkey/ast"
 	"dumch/monkey/code"
 	"dumch/monkey/object"
+	"dumch/monkey/token"
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
 )
 
 type EmittedInstruction struct {
@@ -15,17 +18,33 @@ type EmittedInstruction struct {
 
 type CompilationScope struct {
 	instructions        code.Instructions
+	positions           []code.SourcePosition
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
 }
 
 type Compiler struct {
 	constants []object.Object
+	interned  map[constantKey]int // constant value → index in constants
 
 	symbolTable *SymbolTable
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	pos token.Position // position of the node being compiled
+
+	warnings []Warning
+
+	// limitErr is the first operand emit found too large even for a wide
+	// instruction, Compile returns it
+	limitErr error
+
+	fold bool // evaluate constant expressions at compile time
+
+	// Optimize runs the peephole pass of code.Optimize over every function
+	// and the main program
+	Optimize bool
 }
 
 func New() *Compiler {
@@ -34,11 +53,18 @@ func New() *Compiler {
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
 	return &Compiler{
 		constants:   []object.Object{},
-		symbolTable: NewSymbolTable(),
+		interned:    map[constantKey]int{},
+		symbolTable: symbolTable,
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
+		fold:        true,
 	}
 }
 
@@ -46,27 +72,58 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	compiler := New()
 	compiler.symbolTable = s
 	compiler.constants = constants
+	for i, obj := range constants {
+		if key, ok := keyOf(obj); ok {
+			if _, seen := compiler.interned[key]; !seen {
+				compiler.interned[key] = i
+			}
+		}
+	}
 	return compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	// instructions emitted for this node point to it;
+	// children set their own position and restore this one
+	outerPos := c.pos
+	if pos := node.Pos(); pos.IsValid() {
+		c.pos = pos
+	}
+	defer func() { c.pos = outerPos }()
 
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
+		for i, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
+			// a return at the top level ends the program
+			if c.terminates(s) {
+				c.unreachable(node.Statements[i+1:])
+				break
+			}
 		}
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
 		if err != nil {
 			return err
 		}
+		if c.terminates(node) {
+			return nil // no value is left to pop
+		}
 		c.emit(code.OpPop)
 	case *ast.FunctionLiteral:
 		c.enterScope()
+
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+
 		err := c.Compile(node.Body)
 		if err != nil {
 			return err
@@ -79,9 +136,37 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpReturn)
 		}
 
-		instructions := c.leaveScope()
-		compiledFn := &object.CompiledFunction{Instructions: instructions}
-		c.emit(code.OpConstant, c.addConstant(compiledFn))
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		instructions, positions := c.finishScope()
+		code.MarkTailCalls(instructions)
+		c.leaveScope()
+
+		// put captured values on the stack for OpClosure to collect
+		for _, s := range freeSymbols {
+			c.loadSymbol(s)
+		}
+
+		maxStack, err := code.MaxStackDepth(instructions)
+		if err != nil {
+			return err
+		}
+
+		// relative to the literal, which is where OpClosure is
+		for i := range positions {
+			positions[i].Pos = code.Relative(positions[i].Pos, c.pos)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			MaxStack:      maxStack,
+			LineTable:     code.NewLineTable(positions),
+			Name:          node.Name,
+		}
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
@@ -89,11 +174,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpReturnValue)
 	case *ast.CallExpression:
+		if !code.Fits(code.OpCall, len(node.Arguments)) {
+			return fmt.Errorf("%s: too many arguments: %d",
+				node.Pos(), len(node.Arguments))
+		}
+
 		err := c.Compile(node.Function)
 		if err != nil {
 			return err
 		}
-		c.emit(code.OpCall)
+
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpCall, len(node.Arguments))
 	case *ast.IndexExpression:
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -105,9 +203,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpIndex)
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			returnValue := handleLessThan(c, node)
-			return returnValue
+		if value, ok := c.foldConstant(node); ok {
+			c.emitValue(value)
+			return nil
 		}
 
 		err := c.Compile(node.Left)
@@ -131,14 +229,25 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpDiv)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case "<":
+			c.emit(code.OpLessThan)
+		case ">=":
+			c.emit(code.OpGreaterEqual)
+		case "<=":
+			c.emit(code.OpLessEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 	case *ast.PrefixExpression:
+		if value, ok := c.foldConstant(node); ok {
+			c.emitValue(value)
+			return nil
+		}
+
 		err := c.Compile(node.Right)
 		if err != nil {
 			return err
@@ -149,18 +258,31 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		pos := c.addConstant(integer)
 		c.emit(code.OpConstant, pos)
+	case *ast.BigIntLiteral:
+		integer := &object.BigInt{Value: node.Value}
+		pos := c.addConstant(integer)
+		c.emit(code.OpConstant, pos)
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		pos := c.addConstant(float)
+		c.emit(code.OpConstant, pos)
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		pos := c.addConstant(str)
 		c.emit(code.OpConstant, pos)
 	case *ast.ArrayLiteral:
+		if !code.Fits(code.OpArray, len(node.Elements)) {
+			return fmt.Errorf("%s: too many array elements: %d",
+				node.Pos(), len(node.Elements))
+		}
+
 		for _, el := range node.Elements {
 			err := c.Compile(el)
 			if err != nil {
@@ -169,6 +291,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.HashLiteral:
+		if !code.Fits(code.OpHashMap, len(node.Pairs)*2) {
+			return fmt.Errorf("%s: too many hash pairs: %d",
+				node.Pos(), len(node.Pairs))
+		}
+
 		keys := []ast.Expression{}
 		for k := range node.Pairs {
 			keys = append(keys, k)
@@ -196,13 +323,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpFalse)
 		}
 	case *ast.IfExpression:
+		if value, ok := c.foldConstant(node.Condition); ok {
+			// only the branch taken is compiled, without any jumps
+			if isTruthy(value) {
+				if node.Alternative != nil {
+					c.unreachable(node.Alternative.Statements)
+				}
+				return c.compileBranch(node.Consequence)
+			}
+			c.unreachable(node.Consequence.Statements)
+			return c.compileBranch(node.Alternative)
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
 		}
 
-		// Emit an `OpJumpNotTruthy` with a bogus value
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		// Emit an `OpJumpNotTruthy` with a bogus value. Jumps start wide, as
+		// the target is unknown yet, and are narrowed with the whole scope.
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthyWide, 9999)
 
 		err = c.Compile(node.Consequence)
 		if err != nil {
@@ -212,8 +352,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.removeLastPop()
 		}
 
-		// Emit an `OpJump` with a bogus value
-		jumpPos := c.emit(code.OpJump, 9999)
+		// Emit an `OpJump` with a bogus value, unless the consequence returns
+		jumpPos := -1
+		if !c.blockTerminates(node.Consequence) {
+			jumpPos = c.emit(code.OpJumpWide, 9999)
+		}
 
 		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
@@ -229,17 +372,25 @@ func (c *Compiler) Compile(node ast.Node) error {
 				c.removeLastPop()
 			}
 		}
-		afterAlternativePos := len(c.currentInstructions())
-		c.changeOperand(jumpPos, afterAlternativePos)
+		if jumpPos >= 0 {
+			afterAlternativePos := len(c.currentInstructions())
+			c.changeOperand(jumpPos, afterAlternativePos)
+		}
 
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
+		for i, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
+			if c.terminates(s) {
+				c.unreachable(node.Statements[i+1:])
+				break
+			}
 		}
 	case *ast.LetStatement:
+		// define after the value, which sees an outer binding of the same
+		// name; recursion goes through the function's own name
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
@@ -253,18 +404,65 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return fmt.Errorf("%s: undefined variable %s", node.Pos(), node.Value)
 		}
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpGetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpGetLocal, symbol.Index)
+		c.loadSymbol(symbol)
+	}
+
+	return c.limitErr
+}
+
+// compileBranch leaves the value of block on the stack, null if it has none
+func (c *Compiler) compileBranch(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	if block != nil {
+		err := c.Compile(block)
+		if err != nil {
+			return err
+		}
+		if c.blockTerminates(block) {
+			return nil
 		}
 	}
 
+	last := c.scopes[c.scopeIndex].lastInstruction
+	if c.lastIntructionIs(code.OpPop) && last.Position >= start {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
 	return nil
 }
 
+// emitValue loads a value known at compile time
+func (c *Compiler) emitValue(obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		if obj.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	default:
+		c.emit(code.OpConstant, c.addConstant(obj))
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
 func (c *Compiler) changeOperand(opcodePos int, operand int) {
 	op := code.Opcode(c.currentInstructions()[opcodePos])
 	newInstruction := code.Make(op, operand)
@@ -300,37 +498,117 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.removePositionsFrom(last.Position)
 }
 
-// handleLessThan as greater then
-func handleLessThan(c *Compiler, node *ast.InfixExpression) error {
-	err := c.Compile(node.Right)
-	if err != nil {
-		return err
+func (c *Compiler) removePositionsFrom(offset int) {
+	positions := c.scopes[c.scopeIndex].positions
+	for len(positions) > 0 && positions[len(positions)-1].Offset >= offset {
+		positions = positions[:len(positions)-1]
 	}
+	c.scopes[c.scopeIndex].positions = positions
+}
 
-	err = c.Compile(node.Left)
-	if err != nil {
-		return err
+// addConstant and return its index, a value already in the pool is reused
+func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := keyOf(obj)
+	if ok {
+		if index, seen := c.interned[key]; seen {
+			return index
+		}
 	}
 
-	c.emit(code.OpGreaterThan)
-	return nil
+	c.constants = append(c.constants, obj)
+	index := len(c.constants) - 1
+	if ok {
+		c.interned[key] = index
+	}
+	return index
 }
 
-// addConstant and return added index
-func (c *Compiler) addConstant(obj object.Object) int {
-	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+// constantKey tells constants apart by value
+type constantKey struct {
+	Type  object.ObjectType
+	Value string
 }
 
+func keyOf(obj object.Object) (constantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{obj.Type(), strconv.FormatInt(obj.Value, 10)}, true
+	case *object.BigInt:
+		return constantKey{obj.Type(), obj.Value.String()}, true
+	case *object.Float:
+		// the bits tell 0.0 from -0.0
+		bits := math.Float64bits(obj.Value)
+		return constantKey{obj.Type(), strconv.FormatUint(bits, 16)}, true
+	case *object.String:
+		return constantKey{obj.Type(), obj.Value}, true
+	case *object.CompiledFunction:
+		// the line table is relative to the literal, the same function at
+		// another place has the same one; the name shows in tracebacks
+		value := fmt.Sprintf("%x %d %d %d %q %x", []byte(obj.Instructions),
+			obj.NumLocals, obj.NumParameters, obj.MaxStack, obj.Name,
+			obj.LineTable.Data)
+		return constantKey{obj.Type(), value}, true
+	default:
+		return constantKey{}, false
+	}
+}
+
+// emit appends the instruction, switching to the wide variant of op when
+// the operands need it
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	op, ok := code.Wide(op, operands...)
+	if !ok && c.limitErr == nil {
+		c.limitErr = operandLimitError(c.pos, op, operands)
+	}
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
 	c.setLastInstruction(op, pos)
+	c.addPosition(pos)
 	return pos
 }
 
+// operandLimitError describes an instruction whose operands no variant can
+// hold, in terms of what the program has too many of
+func operandLimitError(pos token.Position, op code.Opcode, operands []int) error {
+	what, count := "", 0
+	switch op {
+	case code.OpConstant:
+		what, count = "constants", operands[0]+1
+	case code.OpClosure:
+		if code.Fits(code.OpClosureWide, operands[0], 0) {
+			what, count = "free variables", operands[1]
+		} else {
+			what, count = "constants", operands[0]+1
+		}
+	case code.OpGetLocal, code.OpSetLocal:
+		what, count = "locals", operands[0]+1
+	case code.OpGetFree:
+		what, count = "free variables", operands[0]+1
+	case code.OpGetGlobal, code.OpSetGlobal:
+		what, count = "globals", operands[0]+1
+	default:
+		def, _ := code.Lookup(byte(op))
+		return fmt.Errorf("%s: operands %v out of range for %s", pos, operands, def.Name)
+	}
+	return fmt.Errorf("%s: too many %s: %d", pos, what, count)
+}
+
+// addPosition maps the instruction at offset to the current node
+func (c *Compiler) addPosition(offset int) {
+	if !c.pos.IsValid() {
+		return
+	}
+	positions := c.scopes[c.scopeIndex].positions
+	if n := len(positions); n > 0 && positions[n-1].Pos == c.pos {
+		return // still the same node, the previous entry covers it
+	}
+	c.scopes[c.scopeIndex].positions = append(positions,
+		code.SourcePosition{Offset: offset, Pos: c.pos})
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	previous := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstruction{Opcode: op, Position: pos}
@@ -374,11 +652,50 @@ func (c *Compiler) leaveScope() code.Instructions {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	MaxStack     int // of the main program, functions carry their own
+	LineTable    code.LineTable
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions, positions := c.finishScope()
+
+	// the main program is as balanced as any function Compile accepted
+	maxStack, _ := code.MaxStackDepth(instructions)
+
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
+		MaxStack:     maxStack,
+		LineTable:    code.NewLineTable(positions),
+	}
+}
+
+// finishScope returns the instructions of the current scope, optimized if
+// asked to and with the wide jumps made plain where the target allows, and
+// the positions moved along
+func (c *Compiler) finishScope() (code.Instructions, []code.SourcePosition) {
+	scope := c.scopes[c.scopeIndex]
+	instructions, positions := scope.instructions, scope.positions
+
+	if c.Optimize {
+		var moved func(int) int
+		instructions, moved = code.Optimize(instructions)
+		positions = movePositions(positions, moved)
+	}
+	instructions, moved := code.NarrowJumps(instructions)
+	return instructions, movePositions(positions, moved)
+}
+
+// movePositions maps the offsets of positions, when removed instructions
+// leave several at one offset the last, of the instruction there, wins
+func movePositions(positions []code.SourcePosition, moved func(int) int) []code.SourcePosition {
+	out := make([]code.SourcePosition, 0, len(positions))
+	for _, p := range positions {
+		p.Offset = moved(p.Offset)
+		if n := len(out); n > 0 && out[n-1].Offset == p.Offset {
+			out = out[:n-1]
+		}
+		out = append(out, p)
 	}
+	return out
 }
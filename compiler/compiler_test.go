@@ -1,12 +1,15 @@
 package compiler
 
 import (
+	"bytes"
 	"dumch/monkey/ast"
 	"dumch/monkey/code"
 	"dumch/monkey/lexer"
 	"dumch/monkey/object"
 	"dumch/monkey/parser"
 	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -112,11 +115,31 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []any{2, 1},
+			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterEqual),
 				code.Make(code.OpPop),
 			},
 		},
@@ -388,14 +411,14 @@ func TestIndexExpression(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1,2,3][1+1]",
-			expectedConstants: []any{1, 2, 3, 1, 1},
+			expectedConstants: []any{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -403,13 +426,13 @@ func TestIndexExpression(t *testing.T) {
 		},
 		{
 			input:             "{1:2}[2-1]",
-			expectedConstants: []any{1, 2, 2, 1},
+			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHashMap, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSub),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -434,7 +457,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -451,7 +474,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -468,7 +491,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -480,7 +503,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -500,8 +523,8 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1), // fn
-				code.Make(code.OpCall),
+				code.Make(code.OpClosure, 1, 0), // fn
+				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -516,17 +539,152 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1), // fn
+				code.Make(code.OpClosure, 1, 0), // fn
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let oneArg = fn(a) { a };
+				oneArg(24);`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				24,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0), // fn
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpCall),
+				code.Make(code.OpConstant, 1), // 24
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let manyArg = fn(a, b, c) { a; b; c };
+				manyArg(24, 25, 26);`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpReturnValue),
+				},
+				24,
+				25,
+				26,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0), // fn
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpCall, 3),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1; "a"; 1; "a"; 2`,
+			expectedConstants: []any{1, "a", 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// same code at another position, the line table is relative
+			input: "fn() { 1 }; fn() { 1 };\n  fn() { 1 }",
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			// the name shows in tracebacks, so it tells functions apart
+			input: "let f = fn() { 1 }; let g = fn() { 1 }",
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
 	}
+
 	runCompilerTests(t, tests)
 }
 
+func TestConstantsAcrossStates(t *testing.T) {
+	symbolTable := NewSymbolTable()
+	constants := []object.Object{}
+
+	// every line of the REPL compiles with the state of the previous ones
+	for _, input := range []string{`let f = fn() { "a" }; 1`, `let f = fn() { "a" }; 1`, `"a"`} {
+		compiler := NewWithState(symbolTable, constants)
+		if err := compiler.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		constants = compiler.Bytecode().Constants
+	}
+
+	err := testConstants(t, "", []any{
+		"a",
+		[]code.Instructions{
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpReturnValue),
+		},
+		1,
+	}, constants)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestCompilerScopes(t *testing.T) {
 	compiler := New()
 	if compiler.scopeIndex != 0 {
@@ -593,7 +751,7 @@ func TestLetStatementScopes(t *testing.T) {
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -612,7 +770,7 @@ func TestLetStatementScopes(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -637,7 +795,28 @@ func TestLetStatementScopes(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let x = 1;
+				fn() { let x = x + 1; x }`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -645,12 +824,480 @@ func TestLetStatementScopes(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `len([]);
+				push([], 1);`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 5),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { len([]) }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a) {
+				  fn(b) { a + b }
+				}`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(a) {
+				  fn(b) {
+				    fn(c) { a + b + c }
+				  }
+				}`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let global = 55;
+				fn() {
+				  let a = 66;
+				  fn() {
+				    let b = 77;
+				    fn() {
+				      let c = 88;
+				      global + a + b + c;
+				    }
+				  }
+				}`,
+			expectedConstants: []any{
+				55,
+				66,
+				77,
+				88,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 3),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 4, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 5, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 6, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `let countDown = fn(x) { countDown(x - 1); };
+				countDown(1);`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let wrapper = fn() {
+				  let countDown = fn(x) { countDown(x - 1); };
+				  countDown(1);
+				};
+				wrapper();`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x", "main.mk:1:1: undefined variable x"},
+		{"let a = 1;\nfn() {\n  a + b\n}", "main.mk:3:7: undefined variable b"},
+		{"let y = y;", "main.mk:1:9: undefined variable y"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.NewWithFilename("main.mk", tt.input))
+		program := p.ParseProgram()
+
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %q, got none", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q",
+				tt.expected, err)
+		}
+	}
+}
+
+func TestInstructionPositions(t *testing.T) {
+	input := "let a = 1;\na + 2"
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	tests := []struct {
+		offset   int
+		expected string
+	}{
+		{0, "1:9"},  // OpConstant 1
+		{3, "1:1"},  // OpSetGlobal a
+		{6, "2:1"},  // OpGetGlobal a
+		{9, "2:5"},  // OpConstant 2
+		{12, "2:3"}, // OpAdd
+		{13, "2:1"}, // OpPop
+	}
+
+	for _, tt := range tests {
+		pos := bytecode.LineTable.PositionAt(tt.offset)
+		if pos.String() != tt.expected {
+			t.Errorf("wrong position at %d. want=%s, got=%s",
+				tt.offset, tt.expected, pos)
+		}
+	}
+}
+
+func TestMaxStackDepth(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedMain int
+		expectedFn   int // of the last function constant, -1 for none
+	}{
+		{"1; 2; 3", 1, -1},
+		{"[1, 2, 3 * 4]", 4, -1},
+		{"{1: 2, 3: 4}", 4, -1},
+		{"if (true) { 1 + 2 } else { 3 }", 2, -1},
+		{"fn(a, b) { a + b }", 1, 2},
+		{"let f = fn(a) { [a, a, a] }; f(1)", 2, 3},
+		{"fn(a) { if (a) { return a; }; puts(a, 1 - 2) }", 1, 4},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		compiler.fold = false
+		err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := compiler.Bytecode()
+
+		if bytecode.MaxStack != tt.expectedMain {
+			t.Errorf("%q: wrong main max stack. want=%d, got=%d",
+				tt.input, tt.expectedMain, bytecode.MaxStack)
+		}
+
+		var fn *object.CompiledFunction
+		for _, c := range bytecode.Constants {
+			if f, ok := c.(*object.CompiledFunction); ok {
+				fn = f
+			}
+		}
+		if tt.expectedFn < 0 {
+			continue
+		}
+		if fn == nil {
+			t.Fatalf("%q: no function constant", tt.input)
+		}
+		if fn.MaxStack != tt.expectedFn {
+			t.Errorf("%q: wrong function max stack. want=%d, got=%d",
+				tt.input, tt.expectedFn, fn.MaxStack)
+		}
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	// constants 0 to 65536, the last one needs a wide index
+	var statements []string
+	for i := 0; i <= 65536; i++ {
+		statements = append(statements, fmt.Sprint(i))
+	}
+	bytecode := compileSource(t, strings.Join(statements, ";"))
+	expectSuffix(t, bytecode.Instructions, concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 65535),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstantWide, 65536),
+		code.Make(code.OpPop),
+	}))
+
+	// locals 0 to 256 in a single function
+	statements = nil
+	for i := 0; i <= 256; i++ {
+		statements = append(statements, fmt.Sprintf("let %s = %d;", letters(i), i))
+	}
+	input := fmt.Sprintf("fn() { %s %s; %s }",
+		strings.Join(statements, " "), letters(255), letters(256))
+	bytecode = compileSource(t, input)
+	fn := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+	expectSuffix(t, fn.Instructions, concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 255),
+		code.Make(code.OpSetLocal, 255),
+		code.Make(code.OpConstant, 256),
+		code.Make(code.OpSetLocalWide, 256),
+		code.Make(code.OpGetLocal, 255),
+		code.Make(code.OpPop),
+		code.Make(code.OpGetLocalWide, 256),
+		code.Make(code.OpReturnValue),
+	}))
+
+	// a consequence longer than 65535 bytes
+	statements = nil
+	for i := 0; i < 17000; i++ {
+		statements = append(statements, fmt.Sprintf("%d;", i))
+	}
+	input = "if (true) { " + strings.Join(statements, " ") + " } else { 17000 }"
+	bytecode = compileSource(t, input)
+	afterConsequence := 6 + 17000*4 - 1 + 5
+	expectPrefix(t, bytecode.Instructions, concatInstructions([]code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpJumpNotTruthyWide, afterConsequence),
+	}))
+	expectSuffix(t, bytecode.Instructions, concatInstructions([]code.Instructions{
+		code.Make(code.OpJumpWide, afterConsequence+3),
+		code.Make(code.OpConstant, 17000),
+		code.Make(code.OpPop),
+	}))
+}
+
+func TestNarrowedJumpPositions(t *testing.T) {
+	bytecode := compileSource(t, "if (true) { 1 } else { 2 };\nlet a = 3")
+
+	// 0000 OpTrue, 0001 OpJumpNotTruthy, 0004 OpConstant, 0007 OpJump,
+	// 0010 OpConstant, 0013 OpPop, 0014 OpConstant, 0017 OpSetGlobal
+	tests := []struct {
+		offset   int
+		expected string
+	}{
+		{10, "1:24"},
+		{14, "2:9"},
+		{17, "2:1"},
+	}
+
+	for _, tt := range tests {
+		pos := bytecode.LineTable.PositionAt(tt.offset)
+		if pos.String() != tt.expected {
+			t.Errorf("wrong position at %d. want=%s, got=%s",
+				tt.offset, tt.expected, pos)
+		}
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	args := strings.Repeat("0, ", 255) + "0"
+
+	// one more than OpSetLocalWide can address, a line each
+	var lets []string
+	for i := 0; i <= 65536; i++ {
+		lets = append(lets, fmt.Sprintf("let %s = 0;", letters(i)))
+	}
+	locals := "fn() {\n" + strings.Join(lets, "\n") + "\n}"
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"puts(" + args + ")", "1:5: too many arguments: 256"},
+		{"[" + strings.Repeat("0, ", 65535) + "0]", "1:1: too many array elements: 65536"},
+		{locals, "65538:1: too many locals: 65537"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error %q", tt.expected)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+// letters spells n in base 26 for identifiers, which can't hold digits;
+// the prefix keeps them clear of keywords
+func letters(n int) string {
+	name := string(rune('a' + n%26))
+	for n /= 26; n > 0; n /= 26 {
+		name = string(rune('a'+n%26)) + name
+	}
+	return "v" + name
+}
+
+func compileSource(t *testing.T, input string) *Bytecode {
+	t.Helper()
+
+	compiler := New()
+	compiler.fold = false
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return compiler.Bytecode()
+}
+
+func expectPrefix(t *testing.T, actual, expected code.Instructions) {
+	t.Helper()
+	if !bytes.HasPrefix(actual, expected) {
+		n := min(len(actual), len(expected))
+		t.Errorf("wrong instructions.\nwant prefix=\n%s\ngot=\n%s",
+			expected, actual[:n])
+	}
+}
+
+func expectSuffix(t *testing.T, actual, expected code.Instructions) {
+	t.Helper()
+	if !bytes.HasSuffix(actual, expected) {
+		n := min(len(actual), len(expected))
+		t.Errorf("wrong instructions.\nwant suffix=\n%s\ngot=\n%s",
+			expected, actual[len(actual)-n:])
+	}
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
 
 	for _, tt := range tests {
 		program := parse(tt.input)
 		compiler := New()
+		compiler.fold = false // keep the operators of the input
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -698,6 +1345,18 @@ func testConstants(
 				return fmt.Errorf("constant %d - testStringObject: %s",
 					i, err)
 			}
+		case float64:
+			float, ok := actual[i].(*object.Float)
+			if !ok || float.Value != constant {
+				return fmt.Errorf("constant %d - not Float %g: %s",
+					i, constant, actual[i].Inspect())
+			}
+		case *big.Int:
+			integer, ok := actual[i].(*object.BigInt)
+			if !ok || integer.Value.Cmp(constant) != 0 {
+				return fmt.Errorf("constant %d - not BigInt %s: %s",
+					i, constant, actual[i].Inspect())
+			}
 		case []code.Instructions:
 			fn, ok := actual[i].(*object.CompiledFunction)
 			if !ok {
@@ -0,0 +1,118 @@
+package compiler
+
+import (
+	"dumch/monkey/ast"
+	"dumch/monkey/object"
+)
+
+// foldConstant is fold, unless the compiler keeps expressions as written
+func (c *Compiler) foldConstant(node ast.Expression) (object.Object, bool) {
+	if !c.fold {
+		return nil, false
+	}
+	return fold(node)
+}
+
+// fold returns the value of an expression made of literals and operators
+// only, computed the way the VM would. Anything that fails at run time, like
+// a division by zero or a type mismatch, is not folded, so the VM still
+// raises the error at its position.
+func fold(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.BigIntLiteral:
+		return &object.BigInt{Value: node.Value}, true
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.Boolean:
+		return &object.Boolean{Value: node.Value}, true
+	case *ast.PrefixExpression:
+		right, ok := fold(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldPrefix(node.Operator, right)
+	case *ast.InfixExpression:
+		left, ok := fold(node.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := fold(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(node.Operator, left, right)
+	default:
+		return nil, false
+	}
+}
+
+func foldPrefix(operator string, right object.Object) (object.Object, bool) {
+	switch operator {
+	case "!":
+		if b, ok := right.(*object.Boolean); ok {
+			return &object.Boolean{Value: !b.Value}, true
+		}
+		// any other constant is truthy
+		return &object.Boolean{Value: false}, true
+	case "-":
+		if object.IsNumber(right) {
+			value, err := object.Negate(right, object.OverflowError)
+			return value, err == nil
+		}
+	}
+	return nil, false
+}
+
+func foldInfix(operator string, left, right object.Object) (object.Object, bool) {
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return foldNumberInfix(operator, left, right)
+	}
+
+	switch left := left.(type) {
+	case *object.String:
+		if right, ok := right.(*object.String); ok {
+			switch operator {
+			case "+":
+				return &object.String{Value: left.Value + right.Value}, true
+			case "==":
+				return &object.Boolean{Value: left.Value == right.Value}, true
+			case "!=":
+				return &object.Boolean{Value: left.Value != right.Value}, true
+			}
+		}
+	case *object.Boolean:
+		if right, ok := right.(*object.Boolean); ok {
+			switch operator {
+			case "==":
+				return &object.Boolean{Value: left.Value == right.Value}, true
+			case "!=":
+				return &object.Boolean{Value: left.Value != right.Value}, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func foldNumberInfix(operator string, left, right object.Object) (object.Object, bool) {
+	switch operator {
+	case "+", "-", "*", "/":
+		// an overflow is left to the policy of the engine running the code
+		value, err := object.Arithmetic(operator, left, right, object.OverflowError)
+		return value, err == nil
+	default:
+		result, err := object.NumberComparison(operator, left, right)
+		return &object.Boolean{Value: result}, err == nil
+	}
+}
+
+// isTruthy mirrors the VM, only false and null are not
+func isTruthy(obj object.Object) bool {
+	if b, ok := obj.(*object.Boolean); ok {
+		return b.Value
+	}
+	return true
+}
@@ -0,0 +1,279 @@
+package compiler
+
+import (
+	"dumch/monkey/code"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "60 * 60 * 24",
+			expectedConstants: []any{86400},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-5 + 10 / 2 - (3 - 1)",
+			expectedConstants: []any{-2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" + "b" + "c"`,
+			expectedConstants: []any{"abc"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "(1 < 2) == true",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `!(2 >= 3); !"a"`,
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 2; x * (3 + 4)",
+			expectedConstants: []any{2, 7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// left for the VM to fail at run time
+			input:             "(2 * 3) / 0",
+			expectedConstants: []any{6, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// an overflow is up to the policy of the engine
+			input:             "9223372036854775807 + 1",
+			expectedConstants: []any{9223372036854775807, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-(-9223372036854775807 - 1)",
+			expectedConstants: []any{-9223372036854775808},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "99999999999999999999 - 99999999999999999998; 1",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "9223372036854775808 * 2 > 9223372036854775807",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = 18446744073709551616; 18446744073709551616 + a",
+			expectedConstants: []any{bigInt("18446744073709551616")},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1.5 * 2 + 0.25; 2.0 > 1",
+			expectedConstants: []any{3.25},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 0.0 and -0.0 are equal but distinct constants
+			input:             "1.0 / 0; 0.0; -0.0",
+			expectedConstants: []any{1.0, 0, 0.0, math.Copysign(0, -1)},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 + true",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpTrue),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" == "a"`,
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" < "b"`,
+			expectedConstants: []any{"a", "b"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runFoldingTests(t, tests)
+}
+
+func TestFoldedConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (1 < 2) { 10 } else { 20 }; 3333",
+			expectedConstants: []any{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 } else { 20 }",
+			expectedConstants: []any{20},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 }",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1; if (true) { }",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (1) { let a = 1; }",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { if (true) { return 1; } }",
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runFoldingTests(t, tests)
+}
+
+func bigInt(s string) *big.Int {
+	v, _ := new(big.Int).SetString(s, 10)
+	return v
+}
+
+func runFoldingTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		err = testInstructions(tt.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("%q: testInstructions failed: %s", tt.input, err)
+		}
+
+		err = testConstants(t, tt.input, tt.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Fatalf("%q: testConstants failed: %s", tt.input, err)
+		}
+	}
+}
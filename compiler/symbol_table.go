@@ -3,8 +3,11 @@ package compiler
 type SymbolScope string
 
 const (
-	GlobalScope SymbolScope = "GLOBAL"
-	LocalScope  SymbolScope = "LOCAL"
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	FreeScope     SymbolScope = "FREE"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -16,13 +19,18 @@ type Symbol struct {
 type SymbolTable struct {
 	Outer *SymbolTable
 
+	// FreeSymbols holds the original symbols of the enclosing scopes,
+	// in the order they were captured
+	FreeSymbols []Symbol
+
 	store          map[string]Symbol
 	numDefinitions int
 }
 
 func NewSymbolTable() *SymbolTable {
 	s := make(map[string]Symbol)
-	return &SymbolTable{store: s}
+	free := []Symbol{}
+	return &SymbolTable{store: s, FreeSymbols: free}
 }
 
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
@@ -43,12 +51,43 @@ func (s *SymbolTable) Define(name string) Symbol {
 	return symbol
 }
 
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName lets a function refer to itself by the name
+// it is bound to, without capturing itself as a free variable
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{
+		Name:  original.Name,
+		Index: len(s.FreeSymbols) - 1,
+		Scope: FreeScope,
+	}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	symbol, ok := s.store[name]
-	if !ok && s.Outer != nil {
-		obj, ok := s.Outer.Resolve(name)
-		// TODO: inplace
-		return obj, ok
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.Resolve(name)
+	if !ok || symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
 	}
-	return symbol, ok
+
+	// local of an enclosing function: capture it
+	return s.defineFree(symbol), true
 }
@@ -0,0 +1,66 @@
+package compiler
+
+import (
+	"dumch/monkey/ast"
+	"dumch/monkey/token"
+)
+
+// Warning is a diagnostic that does not stop compilation
+type Warning struct {
+	Pos     token.Position
+	Message string
+}
+
+func (w Warning) String() string {
+	return w.Pos.String() + ": warning: " + w.Message
+}
+
+// Warnings returns what the compiler found suspicious but compiled anyway
+func (c *Compiler) Warnings() []Warning {
+	return c.warnings
+}
+
+// unreachable records that the statements from stmts on are never run and
+// are left out of the bytecode
+func (c *Compiler) unreachable(stmts []ast.Statement) {
+	if len(stmts) == 0 {
+		return
+	}
+	c.warnings = append(c.warnings, Warning{stmts[0].Pos(), "unreachable code"})
+}
+
+// terminates reports whether running stmt always ends in a return, so the
+// statements after it can never run
+func (c *Compiler) terminates(stmt ast.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.ExpressionStatement:
+		ifExpr, ok := stmt.Expression.(*ast.IfExpression)
+		if !ok {
+			return false
+		}
+		if value, ok := c.foldConstant(ifExpr.Condition); ok {
+			if isTruthy(value) {
+				return c.blockTerminates(ifExpr.Consequence)
+			}
+			return c.blockTerminates(ifExpr.Alternative)
+		}
+		return c.blockTerminates(ifExpr.Consequence) &&
+			c.blockTerminates(ifExpr.Alternative)
+	default:
+		return false
+	}
+}
+
+func (c *Compiler) blockTerminates(block *ast.BlockStatement) bool {
+	if block == nil {
+		return false
+	}
+	for _, s := range block.Statements {
+		if c.terminates(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package compiler
+
+import (
+	"dumch/monkey/code"
+	"testing"
+)
+
+func TestUnreachableCodeIsSkipped(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn() { return 1; 2; let a = 3; }",
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { if (true) { return 1; }; 2 }",
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { if (a) { return 1 } else { return 2 }; a }",
+			expectedConstants: []any{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 9),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "return 5; puts(1)",
+			expectedConstants: []any{5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runFoldingTests(t, tests)
+}
+
+func TestUnreachableCodeWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"fn() { return 1; 2; 3 }", []string{"1:18: warning: unreachable code"}},
+		{
+			"fn(a) {\n  if (a) { return 1 } else { return 2 };\n  a\n}",
+			[]string{"3:3: warning: unreachable code"},
+		},
+		{
+			"fn(a) {\n  if (a) { if (a) { return 1 } } else { return 2 };\n  a\n}",
+			[]string{},
+		},
+		{"if (true) { 1 } else { 2 }", []string{"1:24: warning: unreachable code"}},
+		{"if (1 > 2) { 1 }", []string{"1:14: warning: unreachable code"}},
+		{"if (false) { } else { 2 }", []string{}},
+		{"fn(a) { if (a) { return 1 }; a }", []string{}},
+		{"return 1;\nputs(2)", []string{"2:1: warning: unreachable code"}},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		warnings := compiler.Warnings()
+		if len(warnings) != len(tt.expected) {
+			t.Errorf("%q: wrong number of warnings. want=%d, got=%v",
+				tt.input, len(tt.expected), warnings)
+			continue
+		}
+		for i, w := range warnings {
+			if w.String() != tt.expected[i] {
+				t.Errorf("%q: wrong warning. want=%q, got=%q",
+					tt.input, tt.expected[i], w)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"dumch/monkey/bytecode"
+	"dumch/monkey/compiler"
+	"fmt"
+	"io"
+)
+
+func disasmCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("disasm", stderr)
+	opts := compileFlags(flags)
+	files, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(files) != 1 {
+		fmt.Fprintf(stderr, "monkey: disasm expects one file, got %d\n\n%s",
+			len(files), usage)
+		return exitUsage
+	}
+
+	filename, source, err := readSource(files[0], stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitUsage
+	}
+
+	bc, code := loadBytecode(filename, source, opts, stderr)
+	if code != exitOK {
+		return code
+	}
+
+	if err := bytecode.Disassemble(stdout, bc); err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func asmCommand(args []string, stdin io.Reader, stderr io.Writer) int {
+	flags := newFlagSet("asm", stderr)
+	output := flags.String("o", "", "output file, defaults to the input with .mkc")
+	files, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(files) != 1 {
+		fmt.Fprintf(stderr, "monkey: asm expects one file, got %d\n\n%s",
+			len(files), usage)
+		return exitUsage
+	}
+
+	out, ok := outputName("asm", files[0], *output, stderr)
+	if !ok {
+		return exitUsage
+	}
+
+	filename, source, err := readSource(files[0], stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitUsage
+	}
+
+	bc, err := bytecode.Assemble(bytes.NewReader(source))
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s: %s\n", filename, err)
+		return exitError
+	}
+	return writeBytecode(out, bc, false, stderr)
+}
+
+// loadBytecode decodes a compiled file or compiles a script
+func loadBytecode(filename string, source []byte, opts *compileOptions, stderr io.Writer) (*compiler.Bytecode, int) {
+	if bytecode.IsBytecode(source) {
+		bc, err := bytecode.Decode(bytes.NewReader(source))
+		if err != nil {
+			fmt.Fprintf(stderr, "monkey: %s: %s\n", filename, err)
+			return nil, exitError
+		}
+		return bc, exitOK
+	}
+
+	program, ok := parseSource(filename, source, stderr)
+	if !ok {
+		return nil, exitError
+	}
+	bc, ok := compileProgram(program, opts, stderr)
+	if !ok {
+		return nil, exitError
+	}
+	return bc, exitOK
+}
@@ -3,6 +3,7 @@ package evaluator
 import (
 	"dumch/monkey/ast"
 	"dumch/monkey/object"
+	"dumch/monkey/token"
 	"fmt"
 )
 
@@ -13,6 +14,17 @@ var (
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := eval(node, env)
+
+	// the innermost node that produced an error marks its position
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	// Statements
@@ -23,7 +35,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := evalTail(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
@@ -33,6 +45,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 
+	case *ast.BigIntLiteral:
+		return &object.BigInt{Value: node.Value}
+
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
@@ -54,7 +72,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return evalPrefixExpression(node.Operator, right, env.Overflow)
 
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
@@ -65,7 +83,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixExpression(node.Operator, left, right, env.Overflow)
 
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
@@ -149,6 +167,8 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.ARRAY_OBJ:
+		return newError("array index must be INTEGER, got %s", index.Type())
 	case left.Type() == object.HASH_OBJ:
 		hashObject := left.(*object.Hash)
 		key, ok := index.(object.Hashable)
@@ -176,14 +196,38 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 }
 
 func applyFunction(fn object.Object, args []object.Object) object.Object {
+	return finishTailCalls(callFunction(fn, args))
+}
+
+// finishTailCalls makes the calls handed back from tail positions one after
+// another, so that tail recursion runs in constant Go stack
+func finishTailCalls(result object.Object) object.Object {
+	for {
+		call, ok := result.(*tailCall)
+		if !ok {
+			return result
+		}
+		result = callFunction(call.fn, call.args)
+		if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+			err.Pos = call.pos
+		}
+	}
+}
+
+// callFunction runs fn, a call in tail position of its body is returned as
+// a *tailCall instead of being made
+func callFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
 		envPlus := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, envPlus)
+		evaluated := evalTail(fn.Body, envPlus)
 		return unwrapReturnValue(evaluated)
 
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if result := fn.Fn(args...); result != nil {
+			return result
+		}
+		return NULL
 
 	default:
 		return newError("not a function: %s", fn.Type())
@@ -266,10 +310,13 @@ func isTruthy(obj object.Object) bool {
 func evalInfixExpression(
 	operator string,
 	left, right object.Object,
+	policy object.Overflow,
 ) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+		return evalIntegerInfixExpression(operator, left, right, policy)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return evalNumberInfixExpression(operator, left, right, policy)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
@@ -289,30 +336,35 @@ func evalStringInfixExpression(
 	operator string,
 	left, right object.Object,
 ) object.Object {
-	if operator != "+" {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
 		return newError("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
 }
 
 func evalIntegerInfixExpression(
 	operator string,
 	left, right object.Object,
+	policy object.Overflow,
 ) object.Object {
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 	switch operator {
-	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+	case "+", "-", "*", "/":
+		result, err := object.IntegerArithmetic(operator, left, right, policy)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return result
 
 	// comparisons
 	case "<":
@@ -333,23 +385,56 @@ func evalIntegerInfixExpression(
 	}
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+// evalNumberInfixExpression handles numbers other than two Integers, the
+// result is a Float when either is one
+func evalNumberInfixExpression(
+	operator string,
+	left, right object.Object,
+	policy object.Overflow,
+) object.Object {
+	switch operator {
+	case "+", "-", "*", "/":
+		result, err := object.Arithmetic(operator, left, right, policy)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return result
+	case "<", ">", "==", "!=", ">=", "<=":
+		result, _ := object.NumberComparison(operator, left, right)
+		return nativeBoolToBooleanObject(result)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+func evalPrefixExpression(
+	operator string,
+	right object.Object,
+	policy object.Overflow,
+) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
-		return evalMinusPrefixOperatorExpression(right)
+		return evalMinusPrefixOperatorExpression(right, policy)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
 }
 
-func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+func evalMinusPrefixOperatorExpression(
+	right object.Object,
+	policy object.Overflow,
+) object.Object {
+	if !object.IsNumber(right) {
 		return newError("unknown operator: -%s", right.Type())
 	}
-	val := right.(*object.Integer).Value
-	return &object.Integer{Value: -val}
+	result, err := object.Negate(right, policy)
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
@@ -372,7 +457,7 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
-			return result.Value
+			return finishTailCalls(result.Value)
 		case *object.Error:
 			return result
 		}
@@ -396,6 +481,69 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// evalTail evaluates a node whose value is returned from the function being
+// run. A call there is not made but handed back as a *tailCall.
+func evalTail(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		var result object.Object
+		for i, stmt := range node.Statements {
+			if i == len(node.Statements)-1 {
+				return evalTail(stmt, env)
+			}
+			result = Eval(stmt, env)
+			if result == nil {
+				continue
+			}
+
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+		return result
+
+	case *ast.ExpressionStatement:
+		return evalTail(node.Expression, env)
+
+	case *ast.IfExpression:
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if isTruthy(condition) {
+			return evalTail(node.Consequence, env)
+		} else if node.Alternative != nil {
+			return evalTail(node.Alternative, env)
+		}
+		return NULL
+
+	case *ast.CallExpression:
+		function := Eval(node.Function, env)
+		if isError(function) {
+			return function
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		return &tailCall{fn: function, args: args, pos: node.Pos()}
+
+	default:
+		return Eval(node, env)
+	}
+}
+
+// tailCall is a call left for finishTailCalls to make
+type tailCall struct {
+	fn   object.Object
+	args []object.Object
+	pos  token.Position
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
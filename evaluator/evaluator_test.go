@@ -4,6 +4,7 @@ import (
 	"dumch/monkey/lexer"
 	"dumch/monkey/object"
 	"dumch/monkey/parser"
+	"runtime/debug"
 	"testing"
 )
 
@@ -27,6 +28,7 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"0xFF + 0o17 + 0b1010 + 1_000", 1280},
 	}
 
 	for _, tt := range tests {
@@ -240,6 +242,10 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"1 > 2", false},
 		{"1 < 1", false},
 		{"1 > 1", false},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
 		{"1 == 1", true},
 		{"1 != 1", false},
 		{"1 == 2", false},
@@ -253,6 +259,11 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`let y = "a"; y + "" == "a"`, true},
+		{`("a" + "b") == "ab"`, true},
+		{`"a" == "b"`, false},
 	}
 
 	for _, tt := range tests {
@@ -385,6 +396,18 @@ if (10 > 1) {
 			`{"name": "Monkey"}[fn(x) { x }]`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"10 / (5 - 5)",
+			"division by zero",
+		},
+		{
+			"[1, 2][9223372036854775808]",
+			"array index must be INTEGER, got BIGINT",
+		},
+		{
+			"[1, 2][1.0]",
+			"array index must be INTEGER, got FLOAT",
+		},
 	}
 
 	for _, tt := range tests {
@@ -404,6 +427,131 @@ if (10 > 1) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "main.mk:1:3: type mismatch: INTEGER + BOOLEAN"},
+		{"(5 + true) < (-true)", "main.mk:1:4: type mismatch: INTEGER + BOOLEAN"},
+		{"let f = fn() {\n  foobar\n};\nf()", "main.mk:2:3: identifier not found: foobar"},
+		{`len(1)`, "main.mk:1:4: argument to `len` not supported, got INTEGER"},
+		{"fn() {\n  len(1)\n}()", "main.mk:2:6: argument to `len` not supported, got INTEGER"},
+		{"fn() {\n  return len(1)\n}()", "main.mk:2:13: argument to `len` not supported, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.NewWithFilename("main.mk", tt.input))
+		program := p.ParseProgram()
+		evaluated := Eval(program, object.NewEnvironment())
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Inspect() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q",
+				tt.expected, errObj.Inspect())
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the Inspect of the result
+	}{
+		{"18446744073709551616", "18446744073709551616"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"9223372036854775807 + 9223372036854775807 + 2", "18446744073709551616"},
+		{"18446744073709551616 - 18446744073709551616", "0"},
+		{"-9223372036854775808", "-9223372036854775808"},
+		{"18446744073709551616 > 1", "true"},
+		{"1 >= 18446744073709551616", "false"},
+		{"18446744073709551616 == 4294967296 * 4294967296", "true"},
+		{"let a = 18446744073709551616; a != a + 1", "true"},
+		{"{18446744073709551616: 1}[4294967296 * 4294967296]", "1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestFloats(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the Inspect of the result
+	}{
+		{"3.5", "3.5"},
+		{"1.5 * 2", "3.0"},
+		{"7 / 2.0", "3.5"},
+		{"7 / 2", "3"},
+		{"-2.5", "-2.5"},
+		{"1e21", "1e+21"},
+		{"18446744073709551616 * 0.5", "9.223372036854776e+18"},
+		{"1.0 == 1", "true"},
+		{"2 <= 1.99", "false"},
+		{"9007199254740993 == 9007199254740992.0", "false"},
+		{"let nan = 0.0 * (1e308 * 10.0); nan == nan", "false"},
+		{"{1.5: 1}[3.0 / 2]", "1"},
+		{"1.5 / 0", "division by zero"},
+		{"int(-2.9) + int(\"4\")", "2"},
+		{"float(1) / 4", "0.25"},
+		{"int(1e19)", "10000000000000000000"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		input    string
+		policy   object.Overflow
+		expected string // the Inspect of the result
+	}{
+		{"9223372036854775807 + 1", object.OverflowWrap, "-9223372036854775808"},
+		{"9223372036854775807 + 1", object.OverflowError, "integer overflow"},
+		{"9223372036854775807 + 1", object.OverflowPromote, "9223372036854775808"},
+		{"-9223372036854775807 - 2", object.OverflowPromote, "-9223372036854775809"},
+		{"-(-9223372036854775807 - 1)", object.OverflowError, "integer overflow"},
+		{"-(-9223372036854775807 - 1)", object.OverflowPromote, "9223372036854775808"},
+		{"let big = 4294967296 * 4294967296; big * big / big / big", object.OverflowPromote, "1"},
+		{"let big = 4294967296 * 4294967296; big / 0", object.OverflowPromote, "division by zero"},
+		// function bodies run with the policy of the environment they close over
+		{"let f = fn(a) { a + 1 }; f(9223372036854775807)", object.OverflowError, "integer overflow"},
+		{"let f = fn(a) { a + 1 }; f(9223372036854775807)", object.OverflowWrap, "-9223372036854775808"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Overflow = tt.policy
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%q (%s): want=%s, got=%s", tt.input, tt.policy, tt.expected, got)
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -462,6 +610,32 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestTailCalls(t *testing.T) {
+	// a nested Go call per Monkey call would overflow this
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(100000, 0)", 5000050000},
+		{"let count = fn(n) { if (n == 0) { return 0; }; return count(n - 1); }; count(100000)", 0},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } else { len([1, 2]) } }; f(100000)", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestEveryBuiltinIsDefined(t *testing.T) {
+	for _, def := range object.Builtins {
+		if _, ok := testEval(def.Name).(*object.Builtin); !ok {
+			t.Errorf("builtin %s is not defined in the evaluator", def.Name)
+		}
+	}
+}
+
 func TestBuiltinFunction(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -490,6 +664,7 @@ func TestBuiltinFunction(t *testing.T) {
 		{`let a = [1,2]; push(a, 3)`, []int{1, 2, 3}},
 		{`push([], 1)`, []int{1}},
 		{`first(push([], "a"));`, "a"},
+		{`int("42")`, 42},
 	}
 
 	for _, tt := range tests {
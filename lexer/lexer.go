@@ -1,24 +1,51 @@
 package lexer
 
-import "dumch/monkey/token"
+import (
+	"dumch/monkey/token"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
 
 type Lexer struct {
 	input        string
 	postition    int  // current character's position in input
 	readPosition int  // current reading position in input (after current char)
 	ch           byte // current char under examination
+
+	filename string
+	line     int // line of the current char
+	column   int // column of the current char
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+// NewWithFilename creates a Lexer that reports positions within the named file
+func NewWithFilename(filename, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
+	l.skipShebang()
 	return l
 }
 
+// skipShebang ignores a `#!` first line, so scripts can be executable
+func (l *Lexer) skipShebang() {
+	if l.ch != '#' || l.peekChar() != '!' {
+		return
+	}
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+}
+
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	pos := l.currentPosition()
 
 	switch l.ch {
 	case '+':
@@ -30,9 +57,19 @@ func (l *Lexer) NextToken() token.Token {
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: "<="}
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: ">="}
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ',':
@@ -55,8 +92,10 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Literal = ""
 		tok.Type = token.EOF
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readString()
+		// readString stops at the closing quote, or at EOF
+		l.readChar()
+		return tok
 	case '=':
 		if l.peekChar() == '=' {
 			l.readChar()
@@ -75,21 +114,32 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
 			// readIdentifier already readChar
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos = pos
 			// readNumber already readChar
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
 	}
+	tok.Pos = pos
 	l.readChar()
 	return tok
 }
 
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.postition,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
@@ -107,25 +157,214 @@ func isLetter(ch byte) bool {
 		ch == '_'
 }
 
-func (l *Lexer) readString() string {
-	position := l.postition + 1
+// readString reads a string literal with its escapes decoded. An
+// unterminated string is an ERROR token at the opening quote, an invalid
+// escape is one at the backslash.
+func (l *Lexer) readString() token.Token {
+	tok := token.Token{Type: token.STRING, Pos: l.currentPosition()}
+	var out strings.Builder
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch l.ch {
+		case '"':
+			if tok.Type == token.STRING {
+				tok.Literal = out.String()
+			}
+			return tok
+		case 0:
+			return token.Token{Type: token.ERROR, Literal: "unterminated string", Pos: tok.Pos}
+		case '\\':
+			pos := l.currentPosition()
+			// the first invalid escape is reported, the rest of the string is
+			// still read so that lexing goes on after it
+			if msg := l.readEscape(&out); msg != "" && tok.Type == token.STRING {
+				tok = token.Token{Type: token.ERROR, Literal: msg, Pos: pos}
+			}
+		default:
+			out.WriteByte(l.ch)
+		}
+	}
+}
+
+var simpleEscapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'\\': '\\',
+	'"':  '"',
+}
+
+// readEscape decodes the escape sequence starting at the current backslash
+// into out and describes what is wrong with it, if anything. It stops on
+// the last character of the sequence.
+func (l *Lexer) readEscape(out *strings.Builder) string {
+	start := l.postition
+	l.readChar()
+	if l.ch == 0 {
+		return "" // an unterminated string
+	}
+	if ch, ok := simpleEscapes[l.ch]; ok {
+		out.WriteByte(ch)
+		return ""
+	}
+
+	switch l.ch {
+	case 'x':
+		digits := l.readHexDigits(2)
+		if len(digits) != 2 {
+			return fmt.Sprintf("invalid escape sequence %s, want \\xNN",
+				l.input[start:l.postition+1])
+		}
+		b, _ := strconv.ParseUint(digits, 16, 8)
+		out.WriteByte(byte(b))
+	case 'u':
+		if l.peekChar() != '{' {
+			return "invalid escape sequence \\u, want \\u{...}"
+		}
+		l.readChar()
+		digits := l.readHexDigits(6)
+		if digits == "" || l.peekChar() != '}' {
+			return fmt.Sprintf("invalid escape sequence %s, want \\u{...}",
+				l.input[start:l.postition+1])
+		}
+		l.readChar()
+		r, _ := strconv.ParseUint(digits, 16, 32)
+		if !utf8.ValidRune(rune(r)) {
+			return fmt.Sprintf("escape sequence %s is not a valid code point",
+				l.input[start:l.postition+1])
 		}
+		out.WriteRune(rune(r))
+	default:
+		return fmt.Sprintf("unknown escape sequence \\%c", l.ch)
 	}
-	return l.input[position:l.postition]
+	return ""
+}
+
+// readHexDigits reads up to max hexadecimal digits following the current
+// character
+func (l *Lexer) readHexDigits(max int) string {
+	position := l.readPosition
+	for l.readPosition-position < max && digitValue(l.peekChar()) < 16 {
+		l.readChar()
+	}
+	return l.input[position:l.readPosition]
+}
+
+// readNumber reads an integer, or a float when a fraction or an exponent
+// follows the digits. Integers may have a 0x, 0o or 0b prefix and '_'
+// between digits, decimal ones no leading zero.
+// A malformed literal is an ERROR token whose literal says what is wrong.
+func (l *Lexer) readNumber() (string, token.TokenType) {
+	position := l.postition
+
+	base, name := 10, "decimal"
+	if l.ch == '0' {
+		switch l.peekChar() {
+		case 'x', 'X':
+			base, name = 16, "hexadecimal"
+		case 'o', 'O':
+			base, name = 8, "octal"
+		case 'b', 'B':
+			base, name = 2, "binary"
+		}
+	}
+	if base != 10 {
+		l.readChar()
+		l.readChar()
+		digits := l.readDigits(base)
+		literal := l.input[position:l.postition]
+		if msg := checkDigits(digits, base, true); msg != "" {
+			return fmt.Sprintf("%s in %s literal %q", msg, name, literal), token.ERROR
+		}
+		return literal, token.INT
+	}
+
+	typ := token.TokenType(token.INT)
+	parts := []string{l.readDigits(10)}
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		typ = token.FLOAT
+		l.readChar()
+		parts = append(parts, l.readDigits(10))
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if next == '+' || next == '-' {
+			next = l.peekCharAt(2)
+		}
+		if isDigit(next) {
+			typ = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			parts = append(parts, l.readDigits(10))
+		}
+	}
+
+	literal := l.input[position:l.postition]
+	for _, digits := range parts {
+		if msg := checkDigits(digits, 10, false); msg != "" {
+			return fmt.Sprintf("%s in %s literal %q", msg, name, literal), token.ERROR
+		}
+	}
+	// 010 would be octal in C and Go, here it is neither
+	if typ == token.INT && len(literal) > 1 && literal[0] == '0' {
+		return fmt.Sprintf("leading zero in decimal literal %q, use 0o for octal",
+			literal), token.ERROR
+	}
+	return literal, typ
 }
 
-func (l *Lexer) readNumber() string {
+// readDigits reads the digits of a number in base along with any '_',
+// digits of a smaller base are read too, for checkDigits to reject
+func (l *Lexer) readDigits(base int) string {
 	position := l.postition
-	for isDigit(l.ch) {
+	for isDigit(l.ch) || l.ch == '_' || base == 16 && isHexLetter(l.ch) {
 		l.readChar()
 	}
 	return l.input[position:l.postition]
 }
 
+// checkDigits describes what is wrong with the digits of a literal, if
+// anything. A '_' must sit between two digits, or follow a base prefix.
+func checkDigits(digits string, base int, prefixed bool) string {
+	if strings.Trim(digits, "_") == "" {
+		return "no digits"
+	}
+	for i := 0; i < len(digits); i++ {
+		ch := digits[i]
+		if ch == '_' {
+			after := i == 0 && prefixed || i > 0 && digits[i-1] != '_'
+			before := i+1 < len(digits) && digits[i+1] != '_'
+			if !after || !before {
+				return "'_' must separate successive digits"
+			}
+			continue
+		}
+		if digitValue(ch) >= base {
+			return fmt.Sprintf("invalid digit %q", ch)
+		}
+	}
+	return ""
+}
+
+func isHexLetter(ch byte) bool {
+	return 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func digitValue(ch byte) int {
+	switch {
+	case isDigit(ch):
+		return int(ch - '0')
+	case isHexLetter(ch):
+		return int((ch|0x20)-'a') + 10 // lower case
+	default:
+		return 16
+	}
+}
+
 func (l *Lexer) readIdentifier() string {
 	position := l.postition
 	for isLetter(l.ch) {
@@ -140,6 +379,12 @@ func newToken(tokenType token.TokenType, ch byte) token.Token {
 
 // readChar moves Lexer positions one step further and set char to the next
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -155,3 +400,11 @@ func (l *Lexer) peekChar() byte {
 	}
 	return l.input[l.readPosition]
 }
+
+// peekCharAt looks n characters ahead, peekCharAt(1) is peekChar
+func (l *Lexer) peekCharAt(n int) byte {
+	if l.postition+n >= len(l.input) {
+		return 0
+	}
+	return l.input[l.postition+n]
+}
@@ -26,6 +26,7 @@ func TestNextToken(t *testing.T) {
 
 		10 == 10;
 		10 != 9;
+		10 <= 10 >= 9;
 		"foobar"
 		"foo bar"
 		[1, 2];
@@ -109,6 +110,12 @@ func TestNextToken(t *testing.T) {
 		{token.NOT_EQ, "!="},
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
+		{token.INT, "10"},
+		{token.LT_EQ, "<="},
+		{token.INT, "10"},
+		{token.GT_EQ, ">="},
+		{token.INT, "9"},
+		{token.SEMICOLON, ";"},
 		{token.STRING, "foobar"},
 		{token.STRING, "foo bar"},
 		{token.LBRACKET, "["},
@@ -140,3 +147,211 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Token // types and literals up to EOF
+	}{
+		{"42", []token.Token{{Type: token.INT, Literal: "42"}}},
+		{"3.14", []token.Token{{Type: token.FLOAT, Literal: "3.14"}}},
+		{"1e-9", []token.Token{{Type: token.FLOAT, Literal: "1e-9"}}},
+		{"2.5E+10", []token.Token{{Type: token.FLOAT, Literal: "2.5E+10"}}},
+		{"1e3", []token.Token{{Type: token.FLOAT, Literal: "1e3"}}},
+		{"1.", []token.Token{
+			{Type: token.INT, Literal: "1"},
+			{Type: token.ILLEGAL, Literal: "."},
+		}},
+		{"1e", []token.Token{
+			{Type: token.INT, Literal: "1"},
+			{Type: token.IDENT, Literal: "e"},
+		}},
+		{"2e+", []token.Token{
+			{Type: token.INT, Literal: "2"},
+			{Type: token.IDENT, Literal: "e"},
+			{Type: token.PLUS, Literal: "+"},
+		}},
+		{"0xFF 0Xab 0o17 0b1010", []token.Token{
+			{Type: token.INT, Literal: "0xFF"},
+			{Type: token.INT, Literal: "0Xab"},
+			{Type: token.INT, Literal: "0o17"},
+			{Type: token.INT, Literal: "0b1010"},
+		}},
+		{"1_000_000 0x_FF 1_0.2_5e1_0", []token.Token{
+			{Type: token.INT, Literal: "1_000_000"},
+			{Type: token.INT, Literal: "0x_FF"},
+			{Type: token.FLOAT, Literal: "1_0.2_5e1_0"},
+		}},
+		{"0x;", []token.Token{
+			{Type: token.ERROR, Literal: `no digits in hexadecimal literal "0x"`},
+			{Type: token.SEMICOLON, Literal: ";"},
+		}},
+		{"0b_", []token.Token{
+			{Type: token.ERROR, Literal: `no digits in binary literal "0b_"`},
+		}},
+		{"1__0", []token.Token{
+			{Type: token.ERROR, Literal: `'_' must separate successive digits in decimal literal "1__0"`},
+		}},
+		{"1_", []token.Token{
+			{Type: token.ERROR, Literal: `'_' must separate successive digits in decimal literal "1_"`},
+		}},
+		{"1_.5", []token.Token{
+			{Type: token.ERROR, Literal: `'_' must separate successive digits in decimal literal "1_.5"`},
+		}},
+		{"0x1__2", []token.Token{
+			{Type: token.ERROR, Literal: `'_' must separate successive digits in hexadecimal literal "0x1__2"`},
+		}},
+		{"0b102", []token.Token{
+			{Type: token.ERROR, Literal: `invalid digit '2' in binary literal "0b102"`},
+		}},
+		{"0o78", []token.Token{
+			{Type: token.ERROR, Literal: `invalid digit '8' in octal literal "0o78"`},
+		}},
+		{"0 0.5 00.5 0e1", []token.Token{
+			{Type: token.INT, Literal: "0"},
+			{Type: token.FLOAT, Literal: "0.5"},
+			{Type: token.FLOAT, Literal: "00.5"},
+			{Type: token.FLOAT, Literal: "0e1"},
+		}},
+		{"010", []token.Token{
+			{Type: token.ERROR, Literal: `leading zero in decimal literal "010", use 0o for octal`},
+		}},
+		{"09", []token.Token{
+			{Type: token.ERROR, Literal: `leading zero in decimal literal "09", use 0o for octal`},
+		}},
+		{"0_0", []token.Token{
+			{Type: token.ERROR, Literal: `leading zero in decimal literal "0_0", use 0o for octal`},
+		}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for i, want := range append(tt.expected, token.Token{Type: token.EOF}) {
+			tok := l.NextToken()
+			if tok.Type != want.Type || tok.Literal != want.Literal {
+				t.Errorf("%q: token %d wrong. want=%s %q, got=%s %q", tt.input, i,
+					want.Type, want.Literal, tok.Type, tok.Literal)
+				break
+			}
+		}
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Token // types and literals up to EOF
+	}{
+		{`""`, []token.Token{{Type: token.STRING, Literal: ""}}},
+		{`"a\tb\nc\r"`, []token.Token{{Type: token.STRING, Literal: "a\tb\nc\r"}}},
+		{`"say \"hi\" \\o/"`, []token.Token{{Type: token.STRING, Literal: `say "hi" \o/`}}},
+		{`"\x41\x7a\xFF"`, []token.Token{{Type: token.STRING, Literal: "Az\xff"}}},
+		{`"\u{48}\u{e9}\u{1F600}"`, []token.Token{{Type: token.STRING, Literal: "H\u00e9\U0001F600"}}},
+		{"\"two\nlines\"", []token.Token{{Type: token.STRING, Literal: "two\nlines"}}},
+		{`"abc`, []token.Token{{Type: token.ERROR, Literal: "unterminated string"}}},
+		{`"abc\"`, []token.Token{{Type: token.ERROR, Literal: "unterminated string"}}},
+		{`"\q" + 1`, []token.Token{
+			{Type: token.ERROR, Literal: `unknown escape sequence \q`},
+			{Type: token.PLUS, Literal: "+"},
+			{Type: token.INT, Literal: "1"},
+		}},
+		{`"\x4"`, []token.Token{
+			{Type: token.ERROR, Literal: `invalid escape sequence \x4, want \xNN`},
+		}},
+		{`"\xg1"`, []token.Token{
+			{Type: token.ERROR, Literal: `invalid escape sequence \x, want \xNN`},
+		}},
+		{`"\u41"`, []token.Token{
+			{Type: token.ERROR, Literal: `invalid escape sequence \u, want \u{...}`},
+		}},
+		{`"\u{}"`, []token.Token{
+			{Type: token.ERROR, Literal: `invalid escape sequence \u{, want \u{...}`},
+		}},
+		{`"\u{1234567}"`, []token.Token{
+			{Type: token.ERROR, Literal: `invalid escape sequence \u{123456, want \u{...}`},
+		}},
+		{`"\u{D800}"`, []token.Token{
+			{Type: token.ERROR, Literal: `escape sequence \u{D800} is not a valid code point`},
+		}},
+		{`"\u{110000}"`, []token.Token{
+			{Type: token.ERROR, Literal: `escape sequence \u{110000} is not a valid code point`},
+		}},
+		{`"\q\z`, []token.Token{{Type: token.ERROR, Literal: "unterminated string"}}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for i, want := range append(tt.expected, token.Token{Type: token.EOF}) {
+			tok := l.NextToken()
+			if tok.Type != want.Type || tok.Literal != want.Literal {
+				t.Errorf("%q: token %d wrong. want=%s %q, got=%s %q", tt.input, i,
+					want.Type, want.Literal, tok.Type, tok.Literal)
+				break
+			}
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  x + \"ab\"\n"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+	}{
+		{token.LET, token.Position{Filename: "a.mk", Offset: 0, Line: 1, Column: 1}},
+		{token.IDENT, token.Position{Filename: "a.mk", Offset: 4, Line: 1, Column: 5}},
+		{token.ASSIGN, token.Position{Filename: "a.mk", Offset: 6, Line: 1, Column: 7}},
+		{token.INT, token.Position{Filename: "a.mk", Offset: 8, Line: 1, Column: 9}},
+		{token.SEMICOLON, token.Position{Filename: "a.mk", Offset: 9, Line: 1, Column: 10}},
+		{token.IDENT, token.Position{Filename: "a.mk", Offset: 13, Line: 2, Column: 3}},
+		{token.PLUS, token.Position{Filename: "a.mk", Offset: 15, Line: 2, Column: 5}},
+		{token.STRING, token.Position{Filename: "a.mk", Offset: 17, Line: 2, Column: 7}},
+		{token.EOF, token.Position{Filename: "a.mk", Offset: 22, Line: 3, Column: 1}},
+	}
+
+	l := NewWithFilename("a.mk", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong. expected=%+v, got=%+v", i,
+				tt.expectedPos, tok.Pos)
+		}
+	}
+}
+
+func TestShebangLine(t *testing.T) {
+	input := "#!/usr/bin/env monkey run\nlet x = 1;"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedLine int
+	}{
+		{token.LET, 2},
+		{token.IDENT, 2},
+		{token.ASSIGN, 2},
+		{token.INT, 2},
+		{token.SEMICOLON, 2},
+		{token.EOF, 2},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.Line != tt.expectedLine {
+			t.Fatalf("test[%d] - line wrong. expected=%d, got=%d",
+				i, tt.expectedLine, tok.Pos.Line)
+		}
+	}
+}
@@ -1,19 +1,160 @@
 package main
 
 import (
+	"dumch/monkey/object"
 	"dumch/monkey/repl"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 )
 
+// Exit codes of the monkey command
+const (
+	exitOK    = 0
+	exitError = 1 // the script failed to parse, compile or run
+	exitUsage = 2 // bad command line or unreadable input
+)
+
+const (
+	engineVM   = "vm"
+	engineEval = "eval"
+)
+
+const usage = `usage: monkey <command> [arguments]
+
+commands:
+  run   [--engine=vm|eval] [--overflow=policy] [-O] [-W] <file | ->
+                                            execute a script or a compiled
+                                            .mkc file, - reads stdin
+  build [-o file.mkc] [--strip] [-O] [-W] <file | ->
+                                            compile a script to bytecode
+  disasm [-O] [-W] <file | ->               list the bytecode of a script
+                                            or a compiled .mkc file
+  asm   [-o file.mkc] <file | ->            assemble a disasm listing
+  repl  [--engine=vm|eval] [--overflow=policy]
+                                            start an interactive session
+
+-O runs the peephole optimizer over the compiled bytecode, -W reports
+compiler warnings such as unreachable code. --overflow decides what integer
+arithmetic past 64 bits does: wrap, error or promote to arbitrary
+precision (the default).
+
+Without a command monkey starts the repl.
+`
+
 func main() {
-	user, err := user.Current()
-	if err != nil {
-		panic(err)
-	}
-	fmt.Printf("Hello %s! This is the Monkey programming language!\n",
-		user.Username)
-	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	os.Exit(runCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func runCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return replCommand(nil, stdin, stdout, stderr)
+	}
+
+	switch args[0] {
+	case "run":
+		return runScriptCommand(args[1:], stdin, stdout, stderr)
+	case "build":
+		return buildCommand(args[1:], stdin, stderr)
+	case "disasm":
+		return disasmCommand(args[1:], stdin, stdout, stderr)
+	case "asm":
+		return asmCommand(args[1:], stdin, stderr)
+	case "repl":
+		return replCommand(args[1:], stdin, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "monkey: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+func replCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("repl", stderr)
+	engine := engineFlag(flags)
+	overflow := overflowFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if !validEngine(*engine, stderr) {
+		return exitUsage
+	}
+
+	name := "there"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	fmt.Fprintf(stdout, "Hello %s! This is the Monkey programming language!\n",
+		name)
+	fmt.Fprintf(stdout, "Feel free to type in commands\n")
+
+	if *engine == engineEval {
+		repl.StartEval(stdin, stdout, *overflow)
+	} else {
+		repl.Start(stdin, stdout, *overflow)
+	}
+	return exitOK
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	return flags
+}
+
+// parseInterspersed parses flags placed before or after positional
+// arguments, like `build file.mk -o file.mkc`, and returns the positionals
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func engineFlag(flags *flag.FlagSet) *string {
+	return flags.String("engine", engineVM, "execution engine: vm or eval")
+}
+
+func overflowFlag(flags *flag.FlagSet) *object.Overflow {
+	policy := new(object.Overflow)
+	*policy = object.OverflowPromote
+	flags.Func("overflow", "integer overflow policy: wrap, error or promote",
+		func(name string) (err error) {
+			*policy, err = object.ParseOverflow(name)
+			return err
+		})
+	return policy
+}
+
+// compileOptions are the command line switches for compiling a script
+type compileOptions struct {
+	optimize bool
+	warnings bool
+}
+
+func compileFlags(flags *flag.FlagSet) *compileOptions {
+	opts := &compileOptions{}
+	flags.BoolVar(&opts.optimize, "O", false, "optimize the compiled bytecode")
+	flags.BoolVar(&opts.warnings, "W", false, "report compiler warnings")
+	return opts
+}
+
+func validEngine(engine string, stderr io.Writer) bool {
+	if engine == engineVM || engine == engineEval {
+		return true
+	}
+	fmt.Fprintf(stderr, "monkey: unknown engine %q, want vm or eval\n", engine)
+	return false
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, source string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	ok := write("ok.mk", "#!/usr/bin/env monkey run\nlet a = 1; a + 1;")
+	parseErr := write("parse.mk", "let = 1;")
+	compileErr := write("compile.mk", "x;")
+	runtimeErr := write("runtime.mk", "let f = fn() { -true };\nf();")
+	overflow := write("overflow.mk", "let max = 9223372036854775807;\nmax + 1")
+	zero := write("zero.mk", "let z = 0;\n1 / z")
+
+	tests := []struct {
+		args           []string
+		stdin          string
+		expectedCode   int
+		expectedStderr string
+	}{
+		{[]string{"run", ok}, "", exitOK, ""},
+		{[]string{"run", "--engine=eval", ok}, "", exitOK, ""},
+		{[]string{"run", "-"}, "1 + 1", exitOK, ""},
+		{[]string{"run", parseErr}, "", exitError, "parse.mk:1:5: expected next token"},
+		{[]string{"run", compileErr}, "", exitError, "compile.mk:1:1: undefined variable x"},
+		{[]string{"run", "--engine=eval", compileErr}, "", exitError, "compile.mk:1:1: identifier not found: x"},
+		{[]string{"run", runtimeErr}, "", exitError, "stack traceback:"},
+		{[]string{"run", "--engine=eval", runtimeErr}, "", exitError, "runtime.mk:1:16: unknown operator: -BOOLEAN"},
+		{[]string{"run", overflow}, "", exitOK, ""},
+		{[]string{"run", "--overflow=error", overflow}, "", exitError, "overflow.mk:2:5: integer overflow"},
+		{[]string{"run", "--engine=eval", "--overflow=error", overflow}, "", exitError, "overflow.mk:2:5: integer overflow"},
+		{[]string{"run", "--overflow=promote", overflow}, "", exitOK, ""},
+		{[]string{"run", "--overflow=saturate", overflow}, "", exitUsage, "unknown overflow policy"},
+		{[]string{"run", zero}, "", exitError, "zero.mk:2:3: division by zero"},
+		{[]string{"run", "--engine=eval", zero}, "", exitError, "zero.mk:2:3: division by zero"},
+		{[]string{"run", "--engine=jit", ok}, "", exitUsage, "unknown engine"},
+		{[]string{"run"}, "", exitUsage, "run expects one file"},
+		{[]string{"run", filepath.Join(dir, "missing.mk")}, "", exitUsage, "no such file"},
+		{[]string{"fly"}, "", exitUsage, "unknown command"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := runCommand(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if code != tt.expectedCode {
+			t.Errorf("%v: wrong exit code. want=%d, got=%d (stderr=%q)",
+				tt.args, tt.expectedCode, code, stderr.String())
+		}
+		if !strings.Contains(stderr.String(), tt.expectedStderr) {
+			t.Errorf("%v: stderr does not contain %q. got=%q",
+				tt.args, tt.expectedStderr, stderr.String())
+		}
+	}
+}
+
+func TestBuildAndRunBytecode(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "script.mk")
+	err := os.WriteFile(source, []byte("let f = fn() { -true };\nf();"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand([]string{"build", source}, nil, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("build failed with %d: %s", code, stderr.String())
+	}
+
+	compiled := filepath.Join(dir, "script.mkc")
+	stderr.Reset()
+	code = runCommand([]string{"run", compiled}, nil, &stdout, &stderr)
+	if code != exitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitError, code)
+	}
+	expected := "script.mk:1:16: unsupported type for negation: BOOLEAN"
+	if !strings.Contains(stderr.String(), expected) {
+		t.Errorf("stderr does not contain %q. got=%q", expected, stderr.String())
+	}
+
+	stripped := filepath.Join(dir, "stripped.mkc")
+	code = runCommand([]string{"build", source, "-o", stripped, "--strip"},
+		nil, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("build failed with %d: %s", code, stderr.String())
+	}
+	stderr.Reset()
+	code = runCommand([]string{"run", stripped}, nil, &stdout, &stderr)
+	if code != exitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitError, code)
+	}
+	if strings.Contains(stderr.String(), "script.mk:") {
+		t.Errorf("stripped bytecode reports positions: %q", stderr.String())
+	}
+
+	code = runCommand([]string{"run", "--engine=eval", compiled},
+		nil, &stdout, &stderr)
+	if code != exitUsage {
+		t.Errorf("eval of bytecode: wrong exit code. want=%d, got=%d",
+			exitUsage, code)
+	}
+}
+
+func TestDisasmSourceAndBytecode(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "script.mk")
+	err := os.WriteFile(source, []byte(`puts("hi")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	code := runCommand([]string{"build", source}, nil, &bytes.Buffer{}, &stderr)
+	if code != exitOK {
+		t.Fatalf("build failed with %d: %s", code, stderr.String())
+	}
+
+	var listings []string
+	for _, file := range []string{source, filepath.Join(dir, "script.mkc")} {
+		var stdout bytes.Buffer
+		code := runCommand([]string{"disasm", file}, nil, &stdout, &stderr)
+		if code != exitOK {
+			t.Fatalf("disasm %s failed with %d: %s", file, code, stderr.String())
+		}
+		listings = append(listings, stdout.String())
+	}
+
+	if !strings.Contains(listings[0], `OpConstant 0 ; "hi"`) {
+		t.Errorf("listing lacks annotated constant. got=\n%s", listings[0])
+	}
+	if listings[0] != listings[1] {
+		t.Errorf("listings differ.\nsource=\n%s\ncompiled=\n%s",
+			listings[0], listings[1])
+	}
+}
+
+func TestOptimizeFlag(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "script.mk")
+	err := os.WriteFile(source, []byte("let a = 2;\na * 21"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plain, optimized, stderr bytes.Buffer
+	if code := runCommand([]string{"disasm", source}, nil, &plain, &stderr); code != exitOK {
+		t.Fatalf("disasm failed with %d: %s", code, stderr.String())
+	}
+	if code := runCommand([]string{"disasm", "-O", source}, nil, &optimized, &stderr); code != exitOK {
+		t.Fatalf("disasm -O failed with %d: %s", code, stderr.String())
+	}
+	if strings.Contains(plain.String(), "OpDup") {
+		t.Errorf("listing without -O is optimized. got=\n%s", plain.String())
+	}
+	if !strings.Contains(optimized.String(), "OpDup") {
+		t.Errorf("listing with -O is not optimized. got=\n%s", optimized.String())
+	}
+
+	for _, args := range [][]string{
+		{"run", "-O", source},
+		{"build", source, "-O", "-o", filepath.Join(dir, "script.mkc")},
+		{"run", filepath.Join(dir, "script.mkc")},
+	} {
+		if code := runCommand(args, nil, &bytes.Buffer{}, &stderr); code != exitOK {
+			t.Errorf("%v failed with %d: %s", args, code, stderr.String())
+		}
+	}
+}
+
+func TestWarningsFlag(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "script.mk")
+	err := os.WriteFile(source, []byte("let f = fn() { return 1; 2 };\nf()"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var quiet, warned bytes.Buffer
+	if code := runCommand([]string{"run", source}, nil, &bytes.Buffer{}, &quiet); code != exitOK {
+		t.Fatalf("run failed with %d: %s", code, quiet.String())
+	}
+	if quiet.Len() != 0 {
+		t.Errorf("warnings without -W: %s", quiet.String())
+	}
+
+	if code := runCommand([]string{"run", "-W", source}, nil, &bytes.Buffer{}, &warned); code != exitOK {
+		t.Fatalf("run -W failed with %d: %s", code, warned.String())
+	}
+	expected := source + ":1:26: warning: unreachable code\n"
+	if warned.String() != expected {
+		t.Errorf("wrong warnings. want=%q, got=%q", expected, warned.String())
+	}
+}
+
+func TestAsmReproducesBuild(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "script.mk")
+	err := os.WriteFile(source,
+		[]byte(`let f = fn(x) { if (x > 1) { x } else { 1 } }; puts(f(2));`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listing, stderr bytes.Buffer
+	if code := runCommand([]string{"disasm", source}, nil, &listing, &stderr); code != exitOK {
+		t.Fatalf("disasm failed with %d: %s", code, stderr.String())
+	}
+
+	built := filepath.Join(dir, "built.mkc")
+	code := runCommand([]string{"build", "--strip", "-o", built, source},
+		nil, &bytes.Buffer{}, &stderr)
+	if code != exitOK {
+		t.Fatalf("build failed with %d: %s", code, stderr.String())
+	}
+	assembled := filepath.Join(dir, "assembled.mkc")
+	code = runCommand([]string{"asm", "-o", assembled, "-"},
+		&listing, &bytes.Buffer{}, &stderr)
+	if code != exitOK {
+		t.Fatalf("asm failed with %d: %s", code, stderr.String())
+	}
+
+	want, _ := os.ReadFile(built)
+	got, _ := os.ReadFile(assembled)
+	if !bytes.Equal(want, got) {
+		t.Errorf("assembled file differs from the stripped build")
+	}
+}
+
+func TestRunVerifiesBytecode(t *testing.T) {
+	dir := t.TempDir()
+	compiled := filepath.Join(dir, "bad.mkc")
+
+	var stderr bytes.Buffer
+	listing := strings.NewReader(".main\nOpConstant 7\nOpPop\n")
+	code := runCommand([]string{"asm", "-o", compiled, "-"},
+		listing, &bytes.Buffer{}, &stderr)
+	if code != exitOK {
+		t.Fatalf("asm failed with %d: %s", code, stderr.String())
+	}
+
+	code = runCommand([]string{"run", compiled}, nil, &bytes.Buffer{}, &stderr)
+	if code != exitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitError, code)
+	}
+	expected := "invalid bytecode: main at 0000: constant 7 out of range"
+	if !strings.Contains(stderr.String(), expected) {
+		t.Errorf("stderr does not contain %q. got=%q", expected, stderr.String())
+	}
+}
@@ -0,0 +1,267 @@
+package object
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Overflow is what + - * and negation do when an integer result does not
+// fit in an int64
+type Overflow int
+
+const (
+	OverflowWrap    Overflow = iota // wrap around like Go's int64
+	OverflowError                   // fail with "integer overflow"
+	OverflowPromote                 // continue as a BigInt, the default
+)
+
+var overflowNames = []string{"wrap", "error", "promote"}
+
+func (o Overflow) String() string {
+	if int(o) < len(overflowNames) {
+		return overflowNames[o]
+	}
+	return fmt.Sprintf("Overflow(%d)", int(o))
+}
+
+// ParseOverflow returns the policy called name, as String spells it
+func ParseOverflow(name string) (Overflow, error) {
+	for i, n := range overflowNames {
+		if n == name {
+			return Overflow(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q, want wrap, error or promote", name)
+}
+
+// IsInteger reports whether obj is an Integer or a BigInt
+func IsInteger(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInt:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsNumber reports whether obj is an integer or a Float
+func IsNumber(obj Object) bool {
+	_, ok := obj.(*Float)
+	return ok || IsInteger(obj)
+}
+
+// Arithmetic applies operator, one of + - * /, to two numbers. With a Float
+// among them the other is converted and the result is a Float, integers
+// follow IntegerArithmetic.
+func Arithmetic(operator string, left, right Object, policy Overflow) (Object, error) {
+	if IsInteger(left) && IsInteger(right) {
+		return IntegerArithmetic(operator, left, right, policy)
+	}
+
+	a, b := ToFloat(left), ToFloat(right)
+	switch operator {
+	case "+":
+		return &Float{Value: a + b}, nil
+	case "-":
+		return &Float{Value: a - b}, nil
+	case "*":
+		return &Float{Value: a * b}, nil
+	case "/":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return &Float{Value: a / b}, nil
+	default:
+		return nil, fmt.Errorf("unknown operator: %s", operator)
+	}
+}
+
+// Negate is unary minus on any number
+func Negate(operand Object, policy Overflow) (Object, error) {
+	if f, ok := operand.(*Float); ok {
+		return &Float{Value: -f.Value}, nil
+	}
+	return IntegerNegate(operand, policy)
+}
+
+// NumberComparison applies operator, one of == != < > <= >=, to two
+// numbers. Integers and floats compare by their exact values, NaN is
+// unequal to everything, itself included.
+func NumberComparison(operator string, left, right Object) (bool, error) {
+	cmp, ordered := 0, true
+	if IsInteger(left) && IsInteger(right) {
+		cmp = CompareIntegers(left, right)
+	} else if a, b := toBigFloat(left), toBigFloat(right); a != nil && b != nil {
+		cmp = a.Cmp(b)
+	} else {
+		ordered = false
+	}
+
+	switch operator {
+	case "==":
+		return ordered && cmp == 0, nil
+	case "!=":
+		return !ordered || cmp != 0, nil
+	case "<":
+		return ordered && cmp < 0, nil
+	case ">":
+		return ordered && cmp > 0, nil
+	case "<=":
+		return ordered && cmp <= 0, nil
+	case ">=":
+		return ordered && cmp >= 0, nil
+	default:
+		return false, fmt.Errorf("unknown operator: %s", operator)
+	}
+}
+
+// ToFloat converts a number to the nearest float64
+func ToFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Float:
+		return obj.Value
+	case *Integer:
+		return float64(obj.Value)
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	default:
+		return 0
+	}
+}
+
+// toBigFloat is the exact value of a number, nil for NaN
+func toBigFloat(obj Object) *big.Float {
+	switch obj := obj.(type) {
+	case *Float:
+		if math.IsNaN(obj.Value) {
+			return nil
+		}
+		return new(big.Float).SetFloat64(obj.Value)
+	case *Integer:
+		return new(big.Float).SetInt64(obj.Value)
+	case *BigInt:
+		return new(big.Float).SetInt(obj.Value)
+	default:
+		return nil
+	}
+}
+
+// IntegerArithmetic applies operator, one of + - * /, to two integers as
+// both engines do. Division truncates and fails on a zero divisor.
+func IntegerArithmetic(operator string, left, right Object, policy Overflow) (Object, error) {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if !lok || !rok {
+		return bigArithmetic(operator, toBig(left), toBig(right))
+	}
+
+	a, b := l.Value, r.Value
+	var result int64
+	var overflow bool
+	switch operator {
+	case "+":
+		result = a + b
+		overflow = (a^result)&(b^result) < 0
+	case "-":
+		result = a - b
+		overflow = (a^b)&(a^result) < 0
+	case "*":
+		result = a * b
+		overflow = a != 0 && (result/a != b ||
+			a == -1 && b == math.MinInt64 || b == -1 && a == math.MinInt64)
+	case "/":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result = a / b
+		overflow = a == math.MinInt64 && b == -1
+	default:
+		return nil, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+
+	if !overflow {
+		return &Integer{Value: result}, nil
+	}
+	switch policy {
+	case OverflowError:
+		return nil, fmt.Errorf("integer overflow")
+	case OverflowPromote:
+		return bigArithmetic(operator, big.NewInt(a), big.NewInt(b))
+	default:
+		return &Integer{Value: result}, nil
+	}
+}
+
+// IntegerNegate is unary minus on an Integer or a BigInt
+func IntegerNegate(operand Object, policy Overflow) (Object, error) {
+	i, ok := operand.(*Integer)
+	if !ok {
+		return NewInteger(new(big.Int).Neg(toBig(operand))), nil
+	}
+	if i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}, nil
+	}
+
+	switch policy {
+	case OverflowError:
+		return nil, fmt.Errorf("integer overflow")
+	case OverflowPromote:
+		return NewInteger(new(big.Int).Neg(big.NewInt(i.Value))), nil
+	default:
+		return &Integer{Value: i.Value}, nil
+	}
+}
+
+// CompareIntegers returns -1, 0 or 1 as left is less than, equal to or
+// greater than right, either may be a BigInt
+func CompareIntegers(left, right Object) int {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		return cmp.Compare(l.Value, r.Value)
+	}
+	return toBig(left).Cmp(toBig(right))
+}
+
+func bigArithmetic(operator string, a, b *big.Int) (Object, error) {
+	result := new(big.Int)
+	switch operator {
+	case "+":
+		result.Add(a, b)
+	case "-":
+		result.Sub(a, b)
+	case "*":
+		result.Mul(a, b)
+	case "/":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result.Quo(a, b)
+	default:
+		return nil, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+	return NewInteger(result), nil
+}
+
+func toBig(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInt:
+		return obj.Value
+	default:
+		return new(big.Int)
+	}
+}
+
+// NewInteger returns v as an Integer when it fits in an int64, as a BigInt
+// otherwise. A BigInt never holds a value an Integer could.
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInt{Value: v}
+}
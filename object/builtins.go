@@ -0,0 +1,198 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Builtins are shared by the evaluator and the vm. The order matters:
+// the compiler refers to a builtin by its index in this slice.
+// A builtin returns nil when there is nothing to return,
+// each engine turns it into its own null.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"len",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. "+
+					"Got %d, want 1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(len(arg.Value))}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			default:
+				return newError("argument to `len` not supported, got %s",
+					args[0].Type())
+			}
+		}},
+	},
+	{
+		"puts",
+		&Builtin{Fn: func(args ...Object) Object {
+			for _, arg := range args {
+				fmt.Println(arg.Inspect())
+			}
+
+			return nil
+		}},
+	},
+	{
+		"first",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. "+
+					"Got %d, want 1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *String:
+				if len(arg.Value) == 0 {
+					return nil
+				}
+				return &String{Value: arg.Value[0:1]}
+
+			case *Array:
+				if len(arg.Elements) == 0 {
+					return nil
+				}
+				return arg.Elements[0]
+			}
+
+			return nil
+		}},
+	},
+	{
+		"last",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. "+
+					"Got %d, want 1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *String:
+				l := len(arg.Value)
+				if l == 0 {
+					return nil
+				}
+				return &String{Value: arg.Value[l-1:]}
+
+			case *Array:
+				l := len(arg.Elements)
+				if l == 0 {
+					return nil
+				}
+				return arg.Elements[l-1]
+			}
+
+			return nil
+		}},
+	},
+	{
+		"rest",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. "+
+					"Got %d, want 1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *String:
+				l := len(arg.Value)
+				if l == 0 {
+					return nil
+				}
+				return &String{Value: arg.Value[1:]}
+
+			case *Array:
+				l := len(arg.Elements)
+				if l == 0 {
+					return nil
+				}
+				return &Array{Elements: arg.Elements[1:]}
+			}
+
+			return nil
+		}},
+	},
+	{
+		"push",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. "+
+					"Got %d, want 2", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				l := len(arg.Elements)
+				newElements := make([]Object, l+1, l+1)
+				copy(newElements, arg.Elements)
+				newElements[l] = args[1]
+				return &Array{Elements: newElements}
+			}
+
+			return newError("argument to `push` must be ARRAY, got %s",
+				args[0].Type())
+		}},
+	},
+	{
+		"int",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. "+
+					"Got %d, want 1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer, *BigInt:
+				return arg
+			case *Float:
+				// truncated toward zero, like Go's int64(f)
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					return newError("cannot convert %s to an integer", arg.Inspect())
+				}
+				value, _ := big.NewFloat(arg.Value).Int(nil)
+				return NewInteger(value)
+			case *String:
+				value, ok := new(big.Int).SetString(arg.Value, 10)
+				if !ok {
+					return newError("could not parse %q as integer", arg.Value)
+				}
+				return NewInteger(value)
+			default:
+				return newError("argument to `int` not supported, got %s",
+					args[0].Type())
+			}
+		}},
+	},
+	{
+		"float",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. "+
+					"Got %d, want 1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer, *BigInt, *Float:
+				return &Float{Value: ToFloat(arg)}
+			case *String:
+				value, err := strconv.ParseFloat(arg.Value, 64)
+				if err != nil {
+					return newError("could not parse %q as float", arg.Value)
+				}
+				return &Float{Value: value}
+			default:
+				return newError("argument to `float` not supported, got %s",
+					args[0].Type())
+			}
+		}},
+	},
+}
+
+func newError(format string, a ...any) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -4,24 +4,34 @@ import (
 	"bytes"
 	"dumch/monkey/ast"
 	"dumch/monkey/code"
+	"dumch/monkey/token"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"strconv"
 	"strings"
 )
 
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	// Overflow is what integer arithmetic does past the int64 range,
+	// NewEnvironment sets it to OverflowPromote and enclosed environments
+	// take the one of their outer
+	Overflow Overflow
 }
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	return &Environment{store: s, outer: nil, Overflow: OverflowPromote}
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.Overflow = outer.Overflow
 	return env
 }
 
@@ -42,6 +52,8 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	BIGINT_OBJ            = "BIGINT"
+	FLOAT_OBJ             = "FLOAT"
 	STRING_OBJ            = "STRING"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	ARRAY_OBJ             = "ARRAY"
@@ -50,6 +62,7 @@ const (
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
 	FUNCTION_OBJ          = "FUNCTION"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
+	CLOSURE_OBJ           = "CLOSURE"
 	BUILTIN_OBJ           = "BUILTIN"
 	ERROR_OBJ             = "ERROR"
 )
@@ -66,6 +79,30 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+// BigInt is an integer past the int64 range, see NewInteger
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType { return BIGINT_OBJ }
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect keeps a fraction on whole values, so 2.0 does not read as an
+// Integer
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") { // fraction, exponent, Inf or NaN
+		s += ".0"
+	}
+	return s
+}
+
 type String struct {
 	Value string
 }
@@ -114,10 +151,16 @@ func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
 type Error struct {
 	Message string
+	Pos     token.Position // where the error was raised, if known
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return e.Message }
+func (e *Error) Inspect() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+	return e.Pos.String() + ": " + e.Message
+}
 
 type Function struct {
 	Parameters []*ast.Identifier
@@ -144,7 +187,12 @@ func (f *Function) Inspect() string {
 }
 
 type CompiledFunction struct {
-	Instructions code.Instructions
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	MaxStack      int // deepest the stack gets above the locals
+	LineTable     code.LineTable
+	Name          string // the name of the let binding, if any
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -152,6 +200,20 @@ func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
 
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+
+	// Origin is where the function literal is, the line table of Fn is
+	// relative to it
+	Origin token.Position
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
 type BuiltinFunction func(args ...Object) Object
 
 type Builtin struct {
@@ -212,6 +274,20 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+func (b *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(b.Value.String()))
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+func (f *Float) HashKey() HashKey {
+	value := f.Value
+	if value == 0 {
+		value = 0 // -0.0 and 0.0 are the same key
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	h1 := &String{Value: "Hey!"}
@@ -58,3 +62,177 @@ func TestBooleanHashKey(t *testing.T) {
 		t.Errorf("booleans with different content have same hash keys")
 	}
 }
+
+func TestIntegerArithmetic(t *testing.T) {
+	const max, min = math.MaxInt64, math.MinInt64
+
+	tests := []struct {
+		operator    string
+		left, right int64
+		policy      Overflow
+		expected    string // the Inspect of the result or the error
+	}{
+		{"+", 1, 2, OverflowError, "3"},
+		{"+", max, 1, OverflowWrap, "-9223372036854775808"},
+		{"+", max, 1, OverflowError, "integer overflow"},
+		{"+", max, 1, OverflowPromote, "9223372036854775808"},
+		{"-", min, 1, OverflowError, "integer overflow"},
+		{"-", min, 1, OverflowPromote, "-9223372036854775809"},
+		{"-", -1, max, OverflowError, "-9223372036854775808"},
+		{"*", max, 2, OverflowError, "integer overflow"},
+		{"*", min, -1, OverflowError, "integer overflow"},
+		{"*", -1, min, OverflowPromote, "9223372036854775808"},
+		{"*", 1 << 31, 1 << 31, OverflowError, "4611686018427387904"},
+		{"/", 7, -2, OverflowError, "-3"},
+		{"/", min, -1, OverflowWrap, "-9223372036854775808"},
+		{"/", min, -1, OverflowError, "integer overflow"},
+		{"/", 1, 0, OverflowPromote, "division by zero"},
+	}
+
+	for _, tt := range tests {
+		result, err := IntegerArithmetic(tt.operator,
+			&Integer{Value: tt.left}, &Integer{Value: tt.right}, tt.policy)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = result.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("%d %s %d (%s): want=%s, got=%s",
+				tt.left, tt.operator, tt.right, tt.policy, tt.expected, got)
+		}
+	}
+}
+
+func TestBigIntResultsShrink(t *testing.T) {
+	big, err := IntegerArithmetic("+", &Integer{Value: math.MaxInt64},
+		&Integer{Value: 1}, OverflowPromote)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := big.(*BigInt); !ok {
+		t.Fatalf("result is not BigInt. got=%T", big)
+	}
+
+	back, err := IntegerArithmetic("-", big, &Integer{Value: 1}, OverflowError)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if i, ok := back.(*Integer); !ok || i.Value != math.MaxInt64 {
+		t.Errorf("result is not Integer %d. got=%s", int64(math.MaxInt64), back.Inspect())
+	}
+}
+
+func TestParseOverflow(t *testing.T) {
+	for _, policy := range []Overflow{OverflowWrap, OverflowError, OverflowPromote} {
+		got, err := ParseOverflow(policy.String())
+		if err != nil || got != policy {
+			t.Errorf("ParseOverflow(%q) wrong. got=%s, %v", policy, got, err)
+		}
+	}
+	if _, err := ParseOverflow("saturate"); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
+
+func TestBigIntHashKey(t *testing.T) {
+	v, _ := new(big.Int).SetString("18446744073709551616", 10)
+	big1 := &BigInt{Value: v}
+	big2 := &BigInt{Value: new(big.Int).Set(v)}
+	diff := &BigInt{Value: new(big.Int).Neg(v)}
+
+	if big1.HashKey() != big2.HashKey() {
+		t.Errorf("big integers with same content have different hash keys")
+	}
+	if big1.HashKey() == diff.HashKey() {
+		t.Errorf("big integers with different content have same hash keys")
+	}
+}
+
+func TestCompareIntegers(t *testing.T) {
+	v, _ := new(big.Int).SetString("18446744073709551616", 10)
+	large := &BigInt{Value: v}
+	negative := &BigInt{Value: new(big.Int).Neg(v)}
+
+	tests := []struct {
+		left, right Object
+		expected    int
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 2}, -1},
+		{&Integer{Value: 2}, &Integer{Value: 2}, 0},
+		{large, &Integer{Value: math.MaxInt64}, 1},
+		{&Integer{Value: math.MinInt64}, negative, 1},
+		{negative, large, -1},
+		{large, &BigInt{Value: new(big.Int).Set(v)}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := CompareIntegers(tt.left, tt.right); got != tt.expected {
+			t.Errorf("CompareIntegers(%s, %s) wrong. want=%d, got=%d",
+				tt.left.Inspect(), tt.right.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2, "2.0"},
+		{-3, "-3.0"},
+		{0.25, "0.25"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestFloatHashKey(t *testing.T) {
+	zero := &Float{Value: 0}
+	negativeZero := &Float{Value: math.Copysign(0, -1)}
+	half := &Float{Value: 0.5}
+
+	if zero.HashKey() != negativeZero.HashKey() {
+		t.Errorf("0.0 and -0.0 have different hash keys")
+	}
+	if zero.HashKey() == half.HashKey() {
+		t.Errorf("floats with different content have same hash keys")
+	}
+}
+
+func TestNumberComparison(t *testing.T) {
+	nan := &Float{Value: math.NaN()}
+	v, _ := new(big.Int).SetString("18446744073709551617", 10)
+	large := &BigInt{Value: v}
+
+	tests := []struct {
+		operator    string
+		left, right Object
+		expected    bool
+	}{
+		{"==", &Integer{Value: 1}, &Float{Value: 1}, true},
+		{"<", &Float{Value: 0.5}, &Integer{Value: 1}, true},
+		{">", large, &Float{Value: 18446744073709551616}, true},
+		{"==", nan, nan, false},
+		{"!=", nan, nan, true},
+		{"<", nan, &Integer{Value: 1}, false},
+		{">=", nan, &Integer{Value: 1}, false},
+		{"<", &Float{Value: math.Inf(-1)}, &Integer{Value: math.MinInt64}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := NumberComparison(tt.operator, tt.left, tt.right)
+		if err != nil || got != tt.expected {
+			t.Errorf("%s %s %s wrong. want=%t, got=%t (%v)", tt.left.Inspect(),
+				tt.operator, tt.right.Inspect(), tt.expected, got, err)
+		}
+	}
+}
@@ -5,14 +5,16 @@ import (
 	"dumch/monkey/lexer"
 	"dumch/monkey/token"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 )
 
 const (
 	_ int = iota
 	LOWEST
 	EQUALS      // ==
-	LESSGREATER // > or <
+	LESSGREATER // > or <, >= or <=
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
@@ -25,6 +27,8 @@ var precedences = map[token.TokenType]int{
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
@@ -58,6 +62,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
+	p.registerPrefix(token.ERROR, p.parseErrorToken)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
@@ -78,6 +84,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parserIndexExpression)
 
@@ -144,8 +152,7 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorf(p.curToken.Pos, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
@@ -188,15 +195,46 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
-	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+
+	// base 0 takes the base from a 0x, 0o or 0b prefix, and would take a
+	// leading 0 for octal, so decimal literals are read in base 10
+	digits, base := p.curToken.Literal, 0
+	if len(digits) < 2 || !strings.ContainsRune("xXoObB", rune(digits[1])) {
+		digits, base = strings.ReplaceAll(digits, "_", ""), 10
+	}
+
+	value, err := strconv.ParseInt(digits, base, 64)
+	if err == nil {
+		lit.Value = value
+		return lit
+	}
+
+	bigValue, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		p.errorf(p.curToken.Pos, "could not parse %q as integer",
+			p.curToken.Literal)
+		return lit
+	}
+	return &ast.BigIntLiteral{Token: p.curToken, Value: bigValue}
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken.Pos, "could not parse %q as float",
+			p.curToken.Literal)
 	}
 	lit.Value = value
 	return lit
 }
 
+// parseErrorToken reports a token the lexer found malformed
+func (p *Parser) parseErrorToken() ast.Expression {
+	p.errorf(p.curToken.Pos, "%s", p.curToken.Literal)
+	return nil
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -304,6 +342,10 @@ func (p *Parser) parseExpressionListRecur(
 		p.nextToken()
 		return elements
 	}
+	if p.peekTokenIs(token.EOF) { // unterminated list
+		p.peekError(end)
+		return elements
+	}
 
 	if p.peekTokenIs(token.COMMA) {
 		p.nextToken()
@@ -336,6 +378,10 @@ func (p *Parser) parseFunctionParameters(identifiers []*ast.Identifier) []*ast.I
 		p.nextToken()
 		return identifiers
 	}
+	if p.peekTokenIs(token.EOF) { // unterminated param list
+		p.peekError(token.RPAREN)
+		return identifiers
+	}
 	if p.peekTokenIs(token.COMMA) { // to skip comma
 		p.nextToken()
 	}
@@ -404,6 +450,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	for p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -431,9 +481,14 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got '%s' instead",
+	p.errorf(p.peekToken.Pos, "expected next token to be %s, got '%s' instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+}
+
+// errorf records an error prefixed with the position it refers to
+func (p *Parser) errorf(pos token.Position, format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	p.errors = append(p.errors, fmt.Sprintf("%s: %s", pos, msg))
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -113,6 +113,101 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	testIntegerLiteral(t, stmt.Expression, 5)
 }
 
+func TestBigIntLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807", ""},
+		{"9223372036854775808", "9223372036854775808"},
+		{"99999999999999999999999", "99999999999999999999999"},
+		{"99_999_999_999_999_999_999", "99999999999999999999"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+		if tt.expected == "" {
+			testIntegerLiteral(t, exp, 9223372036854775807)
+			continue
+		}
+		literal, ok := exp.(*ast.BigIntLiteral)
+		if !ok {
+			t.Errorf("exp not *ast.BigIntLiteral. Got %T", exp)
+			continue
+		}
+		if literal.Value.String() != tt.expected {
+			t.Errorf("literal.Value not %s. Got %s", tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestIntegerLiteralBases(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0xFF", 255},
+		{"0x_ff", 255},
+		{"0o17", 15},
+		{"0b1010", 10},
+		{"1_000_000", 1000000},
+		{"0", 0},
+		{"10", 10},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+		literal, ok := exp.(*ast.IntegerLiteral)
+		if !ok || literal.Value != tt.expected {
+			t.Errorf("%q: exp not IntegerLiteral %d. Got %T %s", tt.input, tt.expected, exp, exp)
+		}
+	}
+
+	p := New(lexer.New("0x1_0000_0000_0000_0000"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+	literal, ok := exp.(*ast.BigIntLiteral)
+	if !ok || literal.Value.String() != "18446744073709551616" {
+		t.Errorf("exp not BigIntLiteral 18446744073709551616. Got %T %s", exp, exp)
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E+3", 2500},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+		literal, ok := exp.(*ast.FloatLiteral)
+		if !ok {
+			t.Errorf("exp not *ast.FloatLiteral. Got %T", exp)
+			continue
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. Got %g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"hello world";`
 	l := lexer.New(input)
@@ -272,6 +367,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"5 < 4 != 3 > 4",
 			"((5 < 4) != (3 > 4))",
 		},
+		{
+			"1 + 2 <= 3 == 4 >= 5 * 6",
+			"(((1 + 2) <= 3) == (4 >= (5 * 6)))",
+		},
 		{
 			"3 + 4 * 5 == 3 * 1 + 4 * 5",
 			"((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))",
@@ -700,6 +799,36 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.ReturnValue, "x", "+", "y")
 }
 
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Body does not contain %d statements. Got %d",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. Got %T",
+			program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. Got %T", stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got %q\n",
+			function.Name)
+	}
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -832,6 +961,38 @@ func TestCallExpressionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5;", "main.mk:1:5: expected next token to be IDENT, got '=' instead"},
+		{"let x = 5;\nadd(1, 2", "main.mk:2:9: expected next token to be ), got 'EOF' instead"},
+		{"1 +\n  ;", "main.mk:2:3: no prefix parse function for ; found"},
+		{"let x = 1__0;", "main.mk:1:9: '_' must separate successive digits in decimal literal \"1__0\""},
+		{"1 +\n  0x", "main.mk:2:3: no digits in hexadecimal literal \"0x\""},
+		{"let a = 010;", "main.mk:1:9: leading zero in decimal literal \"010\", use 0o for octal"},
+		{"09", "main.mk:1:1: leading zero in decimal literal \"09\", use 0o for octal"},
+		{"let s = \"a\\qb\";", "main.mk:1:11: unknown escape sequence \\q"},
+		{"puts(1);\nputs(\"abc);", "main.mk:2:6: unterminated string"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.NewWithFilename("main.mk", tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q",
+				tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
 func testLetStatements(t *testing.T, s ast.Statement, name string) bool {
 	if s.TokenLiteral() != "let" {
 		t.Errorf("s.TokenLiteral not 'let'. Got %q", s.TokenLiteral())
@@ -3,6 +3,7 @@ package repl
 import (
 	"bufio"
 	"dumch/monkey/compiler"
+	"dumch/monkey/evaluator"
 	"dumch/monkey/lexer"
 	"dumch/monkey/object"
 	"dumch/monkey/parser"
@@ -13,12 +14,15 @@ import (
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
+func Start(in io.Reader, out io.Writer, overflow object.Overflow) {
 	scanner := bufio.NewScanner(in)
 
 	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
+	var globals []object.Object
 	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
 
 	for {
 		fmt.Print(PROMPT)
@@ -43,23 +47,56 @@ func Start(in io.Reader, out io.Writer) {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
 			continue
 		}
+		// closures of earlier lines refer to their constants by index
+		constants = comp.Bytecode().Constants
 
 		machine := vm.NewWithGlobalStore(comp.Bytecode(), globals)
+		machine.Overflow = overflow
 		err = machine.Run()
+		globals = machine.Globals()
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			msg := err.Error()
+			if rtErr, ok := err.(*vm.RuntimeError); ok {
+				msg = rtErr.Traceback()
+			}
+			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", msg)
 			continue
 		}
 
 		lastPopped := machine.LastPoppedStackElem()
 		io.WriteString(out, lastPopped.Inspect())
 		io.WriteString(out, "\n")
+	}
+}
+
+// StartEval runs the REPL on the tree-walking evaluator instead of the VM
+func StartEval(in io.Reader, out io.Writer, overflow object.Overflow) {
+	scanner := bufio.NewScanner(in)
+	env := object.NewEnvironment()
+	env.Overflow = overflow
+
+	for {
+		fmt.Print(PROMPT)
+		scanned := scanner.Scan()
+		if !scanned {
+			return
+		}
 
-		// evaluated := evaluator.Eval(program, env)
-		// if evaluated != nil {
-		// 	io.WriteString(out, evaluated.Inspect())
-		// 	io.WriteString(out, "\n")
-		// }
+		line := scanner.Text()
+		l := lexer.New(line)
+		p := parser.New(l)
+
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			printParserErrors(out, p.Errors())
+			continue
+		}
+
+		evaluated := evaluator.Eval(program, env)
+		if evaluated != nil {
+			io.WriteString(out, evaluated.Inspect())
+			io.WriteString(out, "\n")
+		}
 	}
 }
 
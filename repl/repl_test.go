@@ -0,0 +1,40 @@
+package repl
+
+import (
+	"bytes"
+	"dumch/monkey/object"
+	"strings"
+	"testing"
+)
+
+func TestStartKeepsStateAcrossLines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let adder = fn(a) { fn(b) { a + b } };
+let x = "hello";
+adder(1)(2)`,
+			"3\n",
+		},
+		{
+			`let a = 10;
+let f = fn() { a * 2 };
+let g = fn() { f() + 1 };
+g()`,
+			"21\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out, object.OverflowPromote)
+
+		// what a let statement prints is of no interest, the last line is
+		if !strings.HasSuffix(out.String(), tt.expected) ||
+			strings.Contains(out.String(), "Woops") {
+			t.Errorf("wrong output. want=%q, got=%q", tt.expected, out.String())
+		}
+	}
+}
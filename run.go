@@ -0,0 +1,127 @@
+package main
+
+import (
+	"dumch/monkey/ast"
+	"dumch/monkey/bytecode"
+	"dumch/monkey/compiler"
+	"dumch/monkey/evaluator"
+	"dumch/monkey/lexer"
+	"dumch/monkey/object"
+	"dumch/monkey/parser"
+	"dumch/monkey/vm"
+	"fmt"
+	"io"
+	"os"
+)
+
+func runScriptCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("run", stderr)
+	engine := engineFlag(flags)
+	overflow := overflowFlag(flags)
+	opts := compileFlags(flags)
+	files, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if !validEngine(*engine, stderr) {
+		return exitUsage
+	}
+	if len(files) != 1 {
+		fmt.Fprintf(stderr, "monkey: run expects one file, got %d\n\n%s",
+			len(files), usage)
+		return exitUsage
+	}
+
+	filename, source, err := readSource(files[0], stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitUsage
+	}
+
+	if *engine == engineEval {
+		if bytecode.IsBytecode(source) {
+			fmt.Fprintf(stderr, "monkey: %s is compiled, it runs on the vm only\n",
+				filename)
+			return exitUsage
+		}
+		program, ok := parseSource(filename, source, stderr)
+		if !ok {
+			return exitError
+		}
+		return evalProgram(program, *overflow, stderr)
+	}
+
+	bc, code := loadBytecode(filename, source, opts, stderr)
+	if code != exitOK {
+		return code
+	}
+	if bytecode.IsBytecode(source) {
+		if err := vm.Verify(bc); err != nil {
+			fmt.Fprintf(stderr, "monkey: %s: invalid bytecode: %s\n", filename, err)
+			return exitError
+		}
+	}
+	return runBytecode(bc, *overflow, stderr)
+}
+
+// readSource reads the named script, "-" stands for stdin
+func readSource(path string, stdin io.Reader) (string, []byte, error) {
+	if path == "-" {
+		source, err := io.ReadAll(stdin)
+		return "<stdin>", source, err
+	}
+	source, err := os.ReadFile(path)
+	return path, source, err
+}
+
+func parseSource(filename string, source []byte, stderr io.Writer) (*ast.Program, bool) {
+	p := parser.New(lexer.NewWithFilename(filename, string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(stderr, msg)
+		}
+		return nil, false
+	}
+	return program, true
+}
+
+func compileProgram(program *ast.Program, opts *compileOptions, stderr io.Writer) (*compiler.Bytecode, bool) {
+	comp := compiler.New()
+	comp.Optimize = opts.optimize
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintln(stderr, err)
+		return nil, false
+	}
+	if opts.warnings {
+		for _, w := range comp.Warnings() {
+			fmt.Fprintln(stderr, w)
+		}
+	}
+	return comp.Bytecode(), true
+}
+
+func runBytecode(bc *compiler.Bytecode, overflow object.Overflow, stderr io.Writer) int {
+	machine := vm.New(bc)
+	machine.Overflow = overflow
+	if err := machine.Run(); err != nil {
+		if rtErr, ok := err.(*vm.RuntimeError); ok {
+			fmt.Fprint(stderr, rtErr.Traceback())
+		} else {
+			fmt.Fprintln(stderr, err)
+		}
+		return exitError
+	}
+	return exitOK
+}
+
+func evalProgram(program *ast.Program, overflow object.Overflow, stderr io.Writer) int {
+	env := object.NewEnvironment()
+	env.Overflow = overflow
+	result := evaluator.Eval(program, env)
+	if errObj, ok := result.(*object.Error); ok {
+		fmt.Fprintln(stderr, errObj.Inspect())
+		return exitError
+	}
+	return exitOK
+}
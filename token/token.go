@@ -1,12 +1,16 @@
 package token
 
+import "fmt"
+
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	ERROR   = "ERROR" // a malformed token, the literal says what is wrong
 
 	// Identifiers + literals
 	IDENT  = "IDENT" // add, foobar, x, y, ...
 	INT    = "INT"   // 1343456
+	FLOAT  = "FLOAT" // 3.14, 1e-9
 	STRING = "STRING"
 
 	// Operators
@@ -17,8 +21,10 @@ const (
 	ASTERISK = "*"
 	SLASH    = "/"
 
-	LT = "<"
-	GT = ">"
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 
 	EQ     = "=="
 	NOT_EQ = "!="
@@ -50,6 +56,29 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
+}
+
+// Position of a token in the source. Line and Column start at 1,
+// Column and Offset are counted in bytes.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String returns file:line:col, or line:col for sources without a name
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,97 @@
+package vm
+
+import (
+	"bytes"
+	"dumch/monkey/code"
+	"dumch/monkey/token"
+	"fmt"
+)
+
+// TraceFrame is a single call frame of a RuntimeError
+type TraceFrame struct {
+	Function string
+	Offset   int // offset of the instruction being executed
+	Pos      token.Position
+}
+
+// RuntimeError is returned by VM.Run. Frames start with the innermost call.
+type RuntimeError struct {
+	Message string
+	Frames  []TraceFrame
+}
+
+// Error returns the message prefixed with the position of the failure
+func (e *RuntimeError) Error() string {
+	if len(e.Frames) == 0 || !e.Frames[0].Pos.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Frames[0].Pos, e.Message)
+}
+
+// Traceback renders the error together with the call stack
+func (e *RuntimeError) Traceback() string {
+	var out bytes.Buffer
+
+	out.WriteString(e.Error())
+	out.WriteString("\nstack traceback:\n")
+	for i := 0; i < len(e.Frames); {
+		f := e.Frames[i]
+		fmt.Fprintf(&out, "\t%s\n\t\t%s (offset %04d)\n",
+			f.Function, f.Pos, f.Offset)
+
+		// deep recursion repeats the same frame, it is listed once
+		n := 1
+		for i+n < len(e.Frames) && e.Frames[i+n] == f {
+			n++
+		}
+		if n > 1 {
+			fmt.Fprintf(&out, "\t... repeated %d more times\n", n-1)
+		}
+		i += n
+	}
+
+	return out.String()
+}
+
+const (
+	mainFunctionName      = "<main>"
+	anonymousFunctionName = "<anonymous>"
+)
+
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	frames := make([]TraceFrame, 0, vm.nextFramesIndex)
+	for i := vm.nextFramesIndex - 1; i >= 0; i-- {
+		f := vm.frames[i]
+
+		name := f.cl.Fn.Name
+		switch {
+		case i == 0:
+			name = mainFunctionName
+		case name == "":
+			name = anonymousFunctionName
+		}
+
+		frames = append(frames, TraceFrame{
+			Function: name,
+			Offset:   instructionStart(f.Instructions(), f.ip),
+			Pos:      f.Position(),
+		})
+	}
+
+	return &RuntimeError{Message: err.Error(), Frames: frames}
+}
+
+// instructionStart finds the offset of the instruction containing ip.
+// While executing, ip points at the last operand byte already read.
+func instructionStart(ins code.Instructions, ip int) int {
+	start := 0
+	for i := 0; i <= ip && i < len(ins); {
+		start = i
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return ip
+		}
+		i += def.Size()
+	}
+	return start
+}
@@ -3,17 +3,24 @@ package vm
 import (
 	"dumch/monkey/code"
 	"dumch/monkey/object"
+	"dumch/monkey/token"
 )
 
 type Frame struct {
-	fn *object.CompiledFunction
-	ip int
+	cl          *object.Closure
+	ip          int
+	basePointer int // stack pointer before the call; locals live above it
 }
 
-func NewFrame(fn *object.CompiledFunction) *Frame {
-	return &Frame{fn: fn, ip: -1}
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
 }
 
 func (f *Frame) Instructions() code.Instructions {
-	return f.fn.Instructions
+	return f.cl.Fn.Instructions
+}
+
+// Position returns the source position of the instruction being executed
+func (f *Frame) Position() token.Position {
+	return code.Resolve(f.cl.Fn.LineTable.PositionAt(f.ip), f.cl.Origin)
 }
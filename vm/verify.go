@@ -0,0 +1,218 @@
+package vm
+
+import (
+	"dumch/monkey/code"
+	"dumch/monkey/compiler"
+	"dumch/monkey/object"
+	"fmt"
+)
+
+// Verify checks bytecode from an untrusted source before it runs. Every
+// function must decode to known opcodes with operands in range, jump to
+// instruction boundaries and have the same stack depth on all paths, within
+// the declared maximum, so that the VM never reads outside its constants,
+// locals or stack.
+func Verify(bc *compiler.Bytecode) error {
+	v := &verifier{constants: bc.Constants, free: map[int]int{}}
+
+	main := &unit{
+		name: "main",
+		fn: &object.CompiledFunction{
+			Instructions: bc.Instructions,
+			MaxStack:     bc.MaxStack,
+		},
+		main:  true,
+		index: -1,
+	}
+	units := []*unit{main}
+	for i, c := range bc.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			units = append(units, &unit{name: functionName(i, fn), fn: fn, index: i})
+		}
+	}
+
+	for _, u := range units {
+		if err := u.decode(); err != nil {
+			return err
+		}
+		if err := v.collectClosures(u); err != nil {
+			return err
+		}
+	}
+	for _, u := range units {
+		u.free = v.free[u.index]
+		if err := v.checkOperands(u); err != nil {
+			return err
+		}
+		if err := u.checkStack(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	constants []object.Object
+	free      map[int]int // constant index of a function → its free variables
+}
+
+// unit is a single function being verified
+type unit struct {
+	name  string
+	fn    *object.CompiledFunction
+	main  bool
+	index int // in the constant pool, -1 for main
+	free  int
+
+	code   []instruction
+	starts map[int]int // offset → index in code
+}
+
+type instruction struct {
+	offset   int
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+}
+
+func functionName(index int, fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return fmt.Sprintf("fn #%d", index)
+	}
+	return fmt.Sprintf("fn #%d %s", index, fn.Name)
+}
+
+func (u *unit) errorf(offset int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s at %04d: %s", u.name, offset, fmt.Sprintf(format, args...))
+}
+
+func (u *unit) decode() error {
+	ins := u.fn.Instructions
+	u.starts = map[int]int{}
+
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return u.errorf(i, "%s", err)
+		}
+		if i+def.Size() > len(ins) {
+			return u.errorf(i, "%s operands truncated", def.Name)
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		u.starts[i] = len(u.code)
+		u.code = append(u.code, instruction{
+			offset:   i,
+			op:       code.Opcode(ins[i]),
+			def:      def,
+			operands: operands,
+		})
+		i += 1 + read
+	}
+	return nil
+}
+
+// collectClosures records how many free variables each function is closed
+// over with, all closures of a function have to agree
+func (v *verifier) collectClosures(u *unit) error {
+	for _, in := range u.code {
+		if in.op != code.OpClosure && in.op != code.OpClosureWide {
+			continue
+		}
+		index, numFree := in.operands[0], in.operands[1]
+		if index >= len(v.constants) {
+			return u.errorf(in.offset, "constant %d out of range", index)
+		}
+		if _, ok := v.constants[index].(*object.CompiledFunction); !ok {
+			return u.errorf(in.offset, "constant %d is not a function", index)
+		}
+		if prev, ok := v.free[index]; ok && prev != numFree {
+			return u.errorf(in.offset, "constant %d closed over %d and %d free variables",
+				index, prev, numFree)
+		}
+		v.free[index] = numFree
+	}
+	return nil
+}
+
+func (v *verifier) checkOperands(u *unit) error {
+	if u.fn.NumParameters > u.fn.NumLocals {
+		return u.errorf(0, "%d parameters but %d locals",
+			u.fn.NumParameters, u.fn.NumLocals)
+	}
+
+	for _, in := range u.code {
+		var index, limit int
+		var what string
+
+		switch in.op {
+		case code.OpConstant, code.OpConstantWide:
+			index, limit, what = in.operands[0], len(v.constants), "constant"
+		case code.OpGetLocal, code.OpSetLocal, code.OpGetLocalWide, code.OpSetLocalWide:
+			index, limit, what = in.operands[0], u.fn.NumLocals, "local"
+		case code.OpGetFree, code.OpGetFreeWide:
+			index, limit, what = in.operands[0], u.free, "free variable"
+		case code.OpGetBuiltin:
+			index, limit, what = in.operands[0], len(object.Builtins), "builtin"
+		case code.OpGetGlobal, code.OpSetGlobal:
+			index, limit, what = in.operands[0], GlobalsSize, "global"
+		case code.OpGetGlobalWide, code.OpSetGlobalWide:
+			index, limit, what = in.operands[0], MaxGlobals, "global"
+		case code.OpHashMap:
+			if in.operands[0]%2 != 0 {
+				return u.errorf(in.offset, "odd number of hash elements %d",
+					in.operands[0])
+			}
+			continue
+		case code.OpJump, code.OpJumpNotTruthy, code.OpJumpWide, code.OpJumpNotTruthyWide:
+			target := in.operands[0]
+			if _, ok := u.starts[target]; !ok && target != len(u.fn.Instructions) {
+				return u.errorf(in.offset, "jump target %04d is not an instruction",
+					target)
+			}
+			continue
+		case code.OpTailCall:
+			if u.main {
+				return u.errorf(in.offset, "tail call outside of a function")
+			}
+			continue
+		default:
+			continue
+		}
+
+		if index >= limit {
+			return u.errorf(in.offset, "%s %d out of range", what, index)
+		}
+	}
+	return nil
+}
+
+// checkStack follows every path through the function, the stack depth
+// before an instruction must not depend on how it was reached and must
+// stay within the declared maximum
+func (u *unit) checkStack() error {
+	depths, err := code.StackDepths(u.fn.Instructions)
+	if err != nil {
+		if stackErr, ok := err.(*code.StackError); ok {
+			return u.errorf(stackErr.Offset, "%s", stackErr.Message)
+		}
+		return err
+	}
+
+	end := len(u.fn.Instructions)
+	if _, ok := depths[end]; ok && !u.main {
+		return u.errorf(end, "execution falls off the end of the function")
+	}
+
+	offsets := make([]int, 0, len(u.code)+1)
+	for _, in := range u.code {
+		offsets = append(offsets, in.offset)
+	}
+	for _, offset := range append(offsets, end) {
+		if depth, ok := depths[offset]; ok && depth > u.fn.MaxStack {
+			return u.errorf(offset, "stack depth %d exceeds the declared %d",
+				depth, u.fn.MaxStack)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package vm
+
+import (
+	"dumch/monkey/bytecode"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRejects(t *testing.T) {
+	tests := []struct {
+		listing  string
+		expected string
+	}{
+		{
+			".main\n.byte 255",
+			"main at 0000: opcode 255 undefined",
+		},
+		{
+			".main\nOpTrue\n.byte 0\n.byte 0",
+			"main at 0001: OpConstant operands truncated",
+		},
+		{
+			".main\nOpConstant 3\nOpPop",
+			"main at 0000: constant 3 out of range",
+		},
+		{
+			".main\nOpGetLocal 0\nOpPop",
+			"main at 0000: local 0 out of range",
+		},
+		{
+			".main\nOpGetBuiltin 200\nOpPop",
+			"main at 0000: builtin 200 out of range",
+		},
+		{
+			".main\nOpGetGlobalWide 16777216\nOpPop",
+			"main at 0000: global 16777216 out of range",
+		},
+		{
+			".main\nOpGetBuiltin 0\nOpTailCall 0\nOpPop",
+			"main at 0002: tail call outside of a function",
+		},
+		{
+			".main\nOpJump 1\nOpPop",
+			"main at 0000: jump target 0001 is not an instruction",
+		},
+		{
+			".main\nOpPop",
+			"main at 0000: OpPop needs 1 values, stack has 0",
+		},
+		{
+			".main\nOpTrue\nOpCall 1\nOpPop",
+			"main at 0001: OpCall needs 2 values, stack has 1",
+		},
+		{
+			".main\nOpTrue\nOpTrue\nOpHash 1",
+			"main at 0002: odd number of hash elements 1",
+		},
+		{
+			".main\nOpTrue\nOpTrue\nOpJumpNotTruthy end\nOpTrue\nend:\nOpPop",
+			"main at 0006: stack depth 1 on one path and 2 on another",
+		},
+		{
+			".const\nint 1\n.main\nOpClosure 0 0\nOpPop",
+			"main at 0000: constant 0 is not a function",
+		},
+		{
+			".const\n.func \"f\"\nOpGetFree 1\nOpReturnValue\n.end\n" +
+				".main\nOpTrue\nOpClosure 0 1\nOpPop",
+			"fn #0 f at 0000: free variable 1 out of range",
+		},
+		{
+			".const\n.func \"f\"\nOpNull\nOpReturnValue\n.end\n" +
+				".main\nOpClosure 0 0\nOpTrue\nOpClosure 0 1\nOpPop\nOpPop",
+			"main at 0005: constant 0 closed over 0 and 1 free variables",
+		},
+		{
+			".const\n.func \"\" params=2 locals=1\nOpReturn\n.end\n.main",
+			"fn #0 at 0000: 2 parameters but 1 locals",
+		},
+		{
+			".main stack=1\nOpTrue\nOpTrue\nOpPop\nOpPop",
+			"main at 0002: stack depth 2 exceeds the declared 1",
+		},
+		{
+			".const\n.func \"f\"\nOpNull\nOpPop\n.end\n.main",
+			"fn #0 f at 0002: execution falls off the end of the function",
+		},
+	}
+
+	for _, tt := range tests {
+		bc, err := bytecode.Assemble(strings.NewReader(tt.listing))
+		if err != nil {
+			t.Fatalf("assemble error: %s", err)
+		}
+
+		err = Verify(bc)
+		if err == nil {
+			t.Errorf("%q: expected error %q", tt.listing, tt.expected)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.listing, tt.expected, err)
+		}
+	}
+}
+
+func TestVerifiedUnsetSlotsReadNull(t *testing.T) {
+	tests := []struct {
+		listing  string
+		expected string // runtime error, "" for none
+	}{
+		{
+			".main stack=2\nOpGetGlobal 5\nOpGetGlobal 6\nOpAdd\nOpPop",
+			"unsupported types for binary operation: NULL NULL",
+		},
+		{
+			".const\n.func \"f\" locals=2\nOpGetLocal 1\nOpReturnValue\n.end\n" +
+				".main\nOpClosure 0 0\nOpCall 0\nOpPop",
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		bc, err := bytecode.Assemble(strings.NewReader(tt.listing))
+		if err != nil {
+			t.Fatalf("assemble error: %s", err)
+		}
+		if err := Verify(bc); err != nil {
+			t.Fatalf("%q: verify error: %s", tt.listing, err)
+		}
+
+		vm := New(bc)
+		err = vm.Run()
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%q: vm error: %s", tt.listing, err)
+			} else if vm.LastPoppedStackElem() != Null {
+				t.Errorf("%q: want null, got %s", tt.listing, vm.LastPoppedStackElem().Inspect())
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.listing, tt.expected, err)
+		}
+	}
+}
@@ -4,12 +4,21 @@ import (
 	"dumch/monkey/code"
 	"dumch/monkey/compiler"
 	"dumch/monkey/object"
+	"dumch/monkey/token"
 	"fmt"
+	"sort"
 )
 
-const StackSize = 2048
-const GlobalsSize = 65536
-const MaxFrames = 1024
+// Default limits of a VM, see VM.StackLimit and VM.FrameLimit
+const StackSize = 1 << 20
+const MaxFrames = 1 << 14
+
+const GlobalsSize = 65536  // globals the narrow instructions can address
+const MaxGlobals = 1 << 24 // the store grows up to this
+
+// the stack and the frames start this small and double as needed
+const initialStackSize = 64
+const initialFrames = 16
 
 var True = &object.Boolean{Value: true}
 var False = &object.Boolean{Value: false}
@@ -25,23 +34,47 @@ type VM struct {
 
 	frames          []*Frame
 	nextFramesIndex int
+
+	// lineTables caches decoded line tables for closureOrigin
+	lineTables map[*object.CompiledFunction][]code.SourcePosition
+
+	// StackLimit and FrameLimit bound how far the stack and the call frames
+	// grow, New sets them to StackSize and MaxFrames
+	StackLimit int
+	FrameLimit int
+
+	// Overflow is what integer arithmetic does past the int64 range
+	Overflow object.Overflow
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
-	mainFrame := NewFrame(mainFn)
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		MaxStack:     bytecode.MaxStack,
+		LineTable:    bytecode.LineTable,
+	}
+	// the main line table is relative to the start of the file
+	mainClosure := &object.Closure{
+		Fn:     mainFn,
+		Origin: token.Position{Filename: bytecode.LineTable.Filename, Line: 1, Column: 1},
+	}
+	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, initialFrames)
 	frames[0] = mainFrame
 
 	return &VM{
 		constants: bytecode.Constants,
-		stack:     make([]object.Object, StackSize),
+		stack:     make([]object.Object, initialStackSize),
 		sp:        0,
-		globals:   make([]object.Object, GlobalsSize),
 
 		frames:          frames,
 		nextFramesIndex: 1,
+		lineTables:      map[*object.CompiledFunction][]code.SourcePosition{},
+
+		StackLimit: StackSize,
+		FrameLimit: MaxFrames,
+		Overflow:   object.OverflowPromote,
 	}
 }
 
@@ -55,7 +88,21 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
+// Run executes the bytecode. Failures are reported as *RuntimeError.
 func (vm *VM) Run() error {
+	err := vm.run()
+	if err == nil {
+		return nil
+	}
+	return vm.newRuntimeError(err)
+}
+
+func (vm *VM) run() error {
+	err := vm.reserveStack(vm.sp + vm.currentFrame().cl.Fn.MaxStack)
+	if err != nil {
+		return err
+	}
+
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
@@ -75,6 +122,13 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpConstantWide:
+			constIndex := code.ReadUint32(ins[ip+1:])
+			vm.currentFrame().ip += 4
+			err := vm.push(vm.constants[constIndex])
+			if err != nil {
+				return err
+			}
 		case code.OpNull:
 			err := vm.push(Null)
 			if err != nil {
@@ -106,17 +160,24 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpReturn:
-			vm.popFrame()
-			vm.pop()
+			if vm.nextFramesIndex == 1 {
+				return vm.returnFromMain(Null)
+			}
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1 // -1 to drop the function itself
+
 			err := vm.push(Null)
 			if err != nil {
 				return err
 			}
 		case code.OpReturnValue:
 			returnValue := vm.pop()
+			if vm.nextFramesIndex == 1 {
+				return vm.returnFromMain(returnValue)
+			}
 
-			vm.popFrame()
-			vm.pop()
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1 // -1 to drop the function itself
 
 			err := vm.push(returnValue)
 			if err != nil {
@@ -124,12 +185,44 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpCall:
-			fn, ok := vm.stack[vm.sp-1].(*object.CompiledFunction)
-			if !ok {
-				return fmt.Errorf("calling non-function")
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+		case code.OpClosureWide:
+			constIndex := code.ReadUint32(ins[ip+1:])
+			numFree := code.ReadUint16(ins[ip+5:])
+			vm.currentFrame().ip += 6
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
+			err := vm.push(vm.currentFrame().cl)
+			if err != nil {
+				return err
 			}
-			frame := NewFrame(fn)
-			vm.pushFrame(frame)
 		case code.OpIndex:
 			index := vm.pop()
 			left := vm.pop()
@@ -137,16 +230,98 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			if err != nil {
+				return err
+			}
+		case code.OpGetLocalWide:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			frame := vm.currentFrame()
+			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			if err != nil {
+				return err
+			}
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+		case code.OpSetLocalWide:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
+			err := vm.push(definition.Builtin)
+			if err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.push(vm.currentFrame().cl.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpGetFreeWide:
+			freeIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			err := vm.push(vm.currentFrame().cl.Free[freeIndex])
+			if err != nil {
+				return err
+			}
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
+			globalIndex := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
-			err := vm.push(vm.globals[globalIndex])
+			err := vm.growGlobals(globalIndex)
+			if err != nil {
+				return err
+			}
+			err = vm.push(vm.globals[globalIndex])
 			if err != nil {
 				return err
 			}
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
+			globalIndex := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
+			err := vm.growGlobals(globalIndex)
+			if err != nil {
+				return err
+			}
+			vm.globals[globalIndex] = vm.pop()
+		case code.OpGetGlobalWide:
+			globalIndex := int(code.ReadUint32(ins[ip+1:]))
+			vm.currentFrame().ip += 4
+			err := vm.growGlobals(globalIndex)
+			if err != nil {
+				return err
+			}
+			err = vm.push(vm.globals[globalIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpSetGlobalWide:
+			globalIndex := int(code.ReadUint32(ins[ip+1:]))
+			vm.currentFrame().ip += 4
+			err := vm.growGlobals(globalIndex)
+			if err != nil {
+				return err
+			}
 			vm.globals[globalIndex] = vm.pop()
 		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
 			err := vm.executeBinaryOperation(op)
@@ -173,7 +348,8 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan,
+			code.OpLessThan, code.OpLessEqual, code.OpGreaterEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -185,21 +361,198 @@ func (vm *VM) Run() error {
 			if !isTruthy(condition) {
 				vm.currentFrame().ip = pos - 1
 			}
+		case code.OpJumpNotTruthyWide:
+			pos := int(code.ReadUint32(ins[ip+1:]))
+			vm.currentFrame().ip += 4
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
 		case code.OpJump:
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip = pos - 1
+		case code.OpJumpWide:
+			pos := int(code.ReadUint32(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
 		case code.OpPop:
 			vm.pop()
+		case code.OpDup:
+			err := vm.push(vm.stack[vm.sp-1])
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+// growGlobals makes room for the global at index, the store starts empty
+// and only grows as far as the program defines globals
+func (vm *VM) growGlobals(index int) error {
+	if index < len(vm.globals) {
+		return nil
+	}
+	if index >= MaxGlobals {
+		return fmt.Errorf("global %d out of range", index)
+	}
+	// bytecode may read a global before it is set, it reads null then
+	for len(vm.globals) <= index {
+		vm.globals = append(vm.globals, Null)
+	}
+	return nil
+}
+
+// returnFromMain ends the program, a return at the top level leaves its
+// value as the last popped element like a final expression statement does
+func (vm *VM) returnFromMain(value object.Object) error {
+	vm.sp = 0
+	err := vm.push(value)
+	if err != nil {
+		return err
+	}
+	vm.pop()
+	return nil
+}
+
+// Globals returns the global store, which may have grown since New
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
+// executeCall expects the callee and numArgs arguments on top of the stack
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("calling non-function")
+	}
+}
+
+// callClosure makes the arguments the first locals of the new frame
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	if vm.nextFramesIndex >= vm.FrameLimit {
+		return fmt.Errorf("maximum recursion depth exceeded")
+	}
+
+	// the frame must fit before it runs, no push inside can overflow then
+	basePointer := vm.sp - numArgs
+	err := vm.reserveStack(basePointer + cl.Fn.NumLocals + cl.Fn.MaxStack)
+	if err != nil {
+		return err
+	}
+
+	frame := NewFrame(cl, basePointer)
+	vm.pushFrame(frame)
+
+	vm.reserveLocals(frame.basePointer, numArgs, cl.Fn.NumLocals)
+	return nil
+}
+
+// reserveLocals sets sp past the local bindings of a frame, the locals
+// after the arguments start as null rather than what the stack held
+func (vm *VM) reserveLocals(basePointer, numArgs, numLocals int) {
+	for i := basePointer + numArgs; i < basePointer+numLocals; i++ {
+		vm.stack[i] = Null
+	}
+	vm.sp = basePointer + numLocals
+}
+
+// executeTailCall calls a closure in place of the current frame, the
+// OpReturnValue that follows the call is then never reached. Builtins are
+// called as usual.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		return vm.executeCall(numArgs)
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	err := vm.reserveStack(frame.basePointer + cl.Fn.NumLocals + cl.Fn.MaxStack)
+	if err != nil {
+		return err
+	}
+
+	// the callee and its arguments take the place of the current ones
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = cl
+	frame.ip = -1
+	vm.reserveLocals(frame.basePointer, numArgs, cl.Fn.NumLocals)
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1 // -1 to drop the builtin itself
+
+	switch result := result.(type) {
+	case nil:
+		return vm.push(Null)
+	case *object.Error:
+		return fmt.Errorf("%s", result.Message)
+	default:
+		return vm.push(result)
+	}
+}
+
+// pushClosure wraps the function constant together with the numFree
+// values on top of the stack, which are the captured free variables
+func (vm *VM) pushClosure(constIndex, numFree int) error {
+	constant := vm.constants[constIndex]
+	fn, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	return vm.push(&object.Closure{Fn: fn, Free: free, Origin: vm.closureOrigin()})
+}
+
+// closureOrigin is the position of the instruction being executed, the
+// OpClosure of a function literal. Closures are created often, so the line
+// tables are decoded once.
+func (vm *VM) closureOrigin() token.Position {
+	f := vm.currentFrame()
+	entries, ok := vm.lineTables[f.cl.Fn]
+	if !ok {
+		entries = f.cl.Fn.LineTable.Entries()
+		vm.lineTables[f.cl.Fn] = entries
+	}
+
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Offset > f.ip })
+	if i == 0 {
+		return token.Position{}
+	}
+	return code.Resolve(entries[i-1].Pos, f.cl.Origin)
+}
+
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.ARRAY_OBJ:
+		return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 	default:
@@ -269,11 +622,14 @@ func isTruthy(obj object.Object) bool {
 
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
-	if operand.Type() != object.INTEGER_OBJ {
+	if !object.IsNumber(operand) {
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
-	value := -operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: value})
+	result, err := object.Negate(operand, vm.Overflow)
+	if err != nil {
+		return err
+	}
+	return vm.push(result)
 }
 
 func (vm *VM) executeBangOperator() error {
@@ -290,21 +646,32 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
-		return vm.executeIntegerComparison(op, left, right)
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return vm.executeNumberComparison(op, left, right)
 	}
 
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
+		return vm.push(nativeBoolToBooleanObject(objectsEqual(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
+		return vm.push(nativeBoolToBooleanObject(!objectsEqual(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d (%s %s)",
 			op, left.Type(), right.Type())
 	}
 }
 
+// objectsEqual is == on anything but numbers: strings compare by value,
+// whether they were constants or built at run time, the rest by identity
+func objectsEqual(left, right object.Object) bool {
+	l, lok := left.(*object.String)
+	r, rok := right.(*object.String)
+	if lok && rok {
+		return l.Value == r.Value
+	}
+	return left == right
+}
+
 func nativeBoolToBooleanObject(b bool) *object.Boolean {
 	if b {
 		return True
@@ -312,26 +679,30 @@ func nativeBoolToBooleanObject(b bool) *object.Boolean {
 	return False
 }
 
-func (vm *VM) executeIntegerComparison(
+func (vm *VM) executeNumberComparison(
 	op code.Opcode,
 	left, right object.Object,
 ) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
-	var result bool
-	switch op {
-	case code.OpEqual:
-		result = leftValue == rightValue
-	case code.OpNotEqual:
-		result = leftValue != rightValue
-	case code.OpGreaterThan:
-		result = leftValue > rightValue
-	default:
+	operator, ok := comparisonOperators[op]
+	if !ok {
 		return fmt.Errorf("unknown operator: %d", op)
 	}
+	result, err := object.NumberComparison(operator, left, right)
+	if err != nil {
+		return err
+	}
 	return vm.push(nativeBoolToBooleanObject(result))
 }
 
+var comparisonOperators = map[code.Opcode]string{
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+}
+
 func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	// mind the order here
 	right := vm.pop()
@@ -341,8 +712,8 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	rightType := right.Type()
 
 	switch {
-	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
-		return vm.executeBinaryIntegerOperation(op, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return vm.executeBinaryNumberOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -351,25 +722,23 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	}
 }
 
-func (vm *VM) executeBinaryIntegerOperation(
+func (vm *VM) executeBinaryNumberOperation(
 	op code.Opcode,
 	left, right object.Object,
 ) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
-	var result int64
-	switch op {
-	case code.OpAdd:
-		result = leftValue + rightValue
-	case code.OpSub:
-		result = leftValue - rightValue
-	case code.OpMul:
-		result = leftValue * rightValue
-	case code.OpDiv:
-		result = leftValue / rightValue
+	result, err := object.Arithmetic(arithmeticOperators[op],
+		left, right, vm.Overflow)
+	if err != nil {
+		return err
 	}
+	return vm.push(result)
+}
 
-	return vm.push(&object.Integer{Value: result})
+var arithmeticOperators = map[code.Opcode]string{
+	code.OpAdd: "+",
+	code.OpSub: "-",
+	code.OpMul: "*",
+	code.OpDiv: "/",
 }
 
 func (vm *VM) executeBinaryStringOperation(
@@ -385,11 +754,29 @@ func (vm *VM) executeBinaryStringOperation(
 	return vm.push(&object.String{Value: str})
 }
 
-func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
+// reserveStack grows the stack to hold size values, at most StackLimit
+func (vm *VM) reserveStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	if size > vm.StackLimit {
 		return fmt.Errorf("stack overflow")
 	}
 
+	grown := max(2*len(vm.stack), size)
+	stack := make([]object.Object, min(grown, vm.StackLimit))
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= len(vm.stack) {
+		if err := vm.reserveStack(vm.sp + 1); err != nil {
+			return err
+		}
+	}
+
 	vm.stack[vm.sp] = o
 	vm.sp++
 	return nil
@@ -404,8 +791,13 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.nextFramesIndex-1]
 }
 
+// pushFrame expects the caller to have checked FrameLimit
 func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.nextFramesIndex] = f
+	if vm.nextFramesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.nextFramesIndex] = f
+	}
 	vm.nextFramesIndex++
 }
 
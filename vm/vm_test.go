@@ -2,11 +2,15 @@ package vm
 
 import (
 	"dumch/monkey/ast"
+	"dumch/monkey/bytecode"
 	"dumch/monkey/compiler"
 	"dumch/monkey/lexer"
 	"dumch/monkey/object"
 	"dumch/monkey/parser"
 	"fmt"
+	"math"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -32,6 +36,8 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-5", -5},
 		{"-50 + 100 + -50", 0},
 		{"(5+10*2 +15/3)*2 + -10", 50},
+		{"let a = 5; a * (2 + 10) - a / -a", 61},
+		{"0xFF + 0o17 + 0b1010 + 1_000", 1280},
 	}
 
 	runVmTests(t, tests)
@@ -45,6 +51,10 @@ func TestBooleanExpresisons(t *testing.T) {
 		{"1 > 2", false},
 		{"1 < 1", false},
 		{"1 > 1", false},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
 		{"1 == 1", true},
 		{"1 != 1", false},
 		{"1 == 2", false},
@@ -88,6 +98,9 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 > 2) { 10 }", Null},
 		{"if (false) { 10 }", Null},
+		{"if (true) { }", Null},
+		{"let t = 1 > 2; if (t) { 10 } else { 20 }", 20},
+		{"let t = true; if (!t) { 10 }", Null},
 	}
 	runVmTests(t, tests)
 }
@@ -106,6 +119,22 @@ func TestStringExpression(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"say \"hi\"\t" + "\x21\u{263A}\n"`, "say \"hi\"\t!\u263a\n"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestStringEquality(t *testing.T) {
+	// by value, wherever the strings came from
+	tests := []vmTestCase{
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`let y = "a"; y + "" == "a"`, true},
+		{`let y = "a"; y + "" != "a"`, false},
+		{`let a = "a"; (a + "b") == "ab"`, true},
+		{`let f = fn(s) { s == "x" }; f("x")`, true},
+		{`"1" == 1`, false},
 	}
 	runVmTests(t, tests)
 }
@@ -192,6 +221,32 @@ func TestFunctionsWithReturnStatement(t *testing.T) {
 		let earlyExit = fn() { return 99; return 100; };
 		earlyExit(); `,
 			expected: 99},
+		{
+			input: `
+		let sign = fn(a) { if (a < 0) { return -1 } else { return 1 }; 0 };
+		sign(-5) * 10 + sign(5)`,
+			expected: -9,
+		},
+		{
+			input: `
+		let first = fn(a) { if (a) { return 1 }; 2 };
+		first(false) * 10 + first(true)`,
+			expected: 21,
+		},
+		{
+			input:    `fn() { if (true) { return 3 } else { 4 }; 5 }()`,
+			expected: 3,
+		},
+		{
+			input:    `return 5; puts(1)`,
+			expected: 5,
+		},
+		{
+			input: `
+		let one = fn() { 1 };
+		if (one() == 1) { return 10 }; 20`,
+			expected: 10,
+		},
 	}
 	runVmTests(t, tests)
 }
@@ -226,8 +281,156 @@ func TestFirstClassFunction(t *testing.T) {
 	runVmTests(t, test)
 }
 
-func runVmTests(t *testing.T, tests []vmTestCase) {
-	t.Helper()
+func TestCallingFunctionsWithBindings(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `let one = fn() { let one = 1; one };
+				one();`,
+			expected: 1,
+		},
+		{
+			input: `let oneAndTwo = fn() { let one = 1; let two = 2; one + two; };
+				oneAndTwo();`,
+			expected: 3,
+		},
+		{
+			input: `let oneAndTwo = fn() { let one = 1; let two = 2; one + two; };
+				let threeAndFour = fn() { let three = 3; let four = 4; three + four; };
+				oneAndTwo() + threeAndFour();`,
+			expected: 10,
+		},
+		{
+			input: `let firstFoobar = fn() { let foobar = 50; foobar; };
+				let secondFoobar = fn() { let foobar = 100; foobar; };
+				firstFoobar() + secondFoobar();`,
+			expected: 150,
+		},
+		{
+			input: `let globalSeed = 50;
+				let minusOne = fn() { let num = 1; globalSeed - num; }
+				let minusTwo = fn() { let num = 2; globalSeed - num; }
+				minusOne() + minusTwo();`,
+			expected: 97,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `let identity = fn(a) { a; };
+				identity(4);`,
+			expected: 4,
+		},
+		{
+			input: `let sum = fn(a, b) { a + b; };
+				sum(1, 2);`,
+			expected: 3,
+		},
+		{
+			input:    `fn(a, b) { a + b }(1, 2)`,
+			expected: 3,
+		},
+		{
+			input: `let sum = fn(a, b) { let c = a + b; c; };
+				sum(1, 2) + sum(3, 4);`,
+			expected: 10,
+		},
+		{
+			input: `let sum = fn(a, b) { let c = a + b; c; };
+				let outer = fn() { sum(1, 2) + sum(3, 4); };
+				outer();`,
+			expected: 10,
+		},
+		{
+			input: `let globalNum = 10;
+				let sum = fn(a, b) { let c = a + b; c + globalNum; };
+				let outer = fn() { sum(1, 2) + sum(3, 4) + globalNum; };
+				outer() + globalNum;`,
+			expected: 50,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    `fn() { 1; }(1);`,
+			expected: `1:12: wrong number of arguments: want=0, got=1`,
+		},
+		{
+			input:    `fn(a) { a; }();`,
+			expected: `1:13: wrong number of arguments: want=1, got=0`,
+		},
+		{
+			input:    `fn(a, b) { a + b; }(1);`,
+			expected: `1:20: wrong number of arguments: want=2, got=1`,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`puts("hello", "world!")`, Null},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, Null},
+		{`first("abc")`, "a"},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, Null},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`rest([])`, Null},
+		{`push([], 1)`, []int{1}},
+		{`let double = fn(a) { len(a) * 2 }; double("ab")`, 4},
+		{`int(2.9)`, 2},
+		{`int(-2.9)`, -2},
+		{`int("-17")`, -17},
+		{`int(7)`, 7},
+		{`int(1e19)`, new(big.Int).SetUint64(1e19)},
+		{`float(3)`, 3.0},
+		{`float("0.25")`, 0.25},
+		{`float(18446744073709551616)`, 18446744073709551616.0},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctionErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`len(1)`, "1:4: argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "1:4: wrong number of arguments. Got 2, want 1"},
+		{`first(1, 2)`, "1:6: wrong number of arguments. Got 2, want 1"},
+		{`push(1, 1)`, "1:5: argument to `push` must be ARRAY, got INTEGER"},
+		{`int("1.5")`, "1:4: could not parse \"1.5\" as integer"},
+		{`int(true)`, "1:4: argument to `int` not supported, got BOOLEAN"},
+		{`let inf = 1e308 * 10.0; int(inf)`, "1:28: cannot convert +Inf to an integer"},
+		{`float("pi")`, "1:6: could not parse \"pi\" as float"},
+	}
 
 	for _, tt := range tests {
 		program := parse(tt.input)
@@ -240,12 +443,731 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 
 		vm := New(comp.Bytecode())
 		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestRuntimeErrorPositions(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 + \"a\"", "main.mk:1:3: unsupported types for binary operation: INTEGER STRING"},
+		{"let f = fn(a) {\n  -a\n};\nf(true)", "main.mk:2:3: unsupported type for negation: BOOLEAN"},
+		{"let x = 1;\nx()", "main.mk:2:2: calling non-function"},
+		{"(1 + \"a\") < (-true)", "main.mk:1:4: unsupported types for binary operation: INTEGER STRING"},
+		{"(-true) >= (1 + \"a\")", "main.mk:1:2: unsupported type for negation: BOOLEAN"},
+		{"let a = 1;\na;\nlet b = a;\nb + true", "main.mk:4:3: unsupported types for binary operation: INTEGER BOOLEAN"},
+		{"let f = fn(a) {\n  10 / a\n};\nf(0)", "main.mk:2:6: division by zero"},
+		{"1 / 0", "main.mk:1:3: division by zero"},
+		{"let z = 0.0;\n1.5 / z", "main.mk:2:5: division by zero"},
+		{"[1, 2][9223372036854775808]", "main.mk:1:7: array index must be INTEGER, got BIGINT"},
+		{"let i = 1.0;\n[1, 2][i]", "main.mk:2:7: array index must be INTEGER, got FLOAT"},
+		// one constant for both functions, each reports its own position
+		{"let fs = [fn(a) { 10 / a }, fn(a) { 10 / a }];\nfs[1](0)", "main.mk:1:40: division by zero"},
+		{"let fs = [fn(a) { 10 / a },\n  fn(a) {\n    10 / a }];\nfs[1](0)", "main.mk:3:8: division by zero"},
+		{"let f = fn() { fn(a) {\n  a + true } };\nlet g = f();\ng(1)", "main.mk:2:5: unsupported types for binary operation: INTEGER BOOLEAN"},
+	}
+
+	for _, optimize := range []bool{false, true} {
+		for _, tt := range tests {
+			p := parser.New(lexer.NewWithFilename("main.mk", tt.input))
+			program := p.ParseProgram()
+
+			comp := compiler.New()
+			comp.Optimize = optimize
+			err := comp.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+			if err == nil {
+				t.Fatalf("expected VM error but resulted in none.")
+			}
+
+			if err.Error() != tt.expected {
+				t.Errorf("wrong VM error (optimize=%t): want=%q, got=%q",
+					optimize, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestRuntimeErrorTraceback(t *testing.T) {
+	// calls in tail position would reuse the frames
+	input := `let inner = fn(a) {
+  -a
+};
+let outer = fn() {
+  let r = fn() { let r = inner(true); r }();
+  r
+};
+outer();`
+
+	p := parser.New(lexer.NewWithFilename("main.mk", input))
+	program := p.ParseProgram()
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError, got=%T (%v)", err, err)
+	}
+
+	expectedFrames := []TraceFrame{
+		{Function: "inner", Offset: 2},
+		{Function: "<anonymous>", Offset: 4},
+		{Function: "outer", Offset: 4},
+		{Function: "<main>", Offset: 17},
+	}
+	expectedPositions := []string{
+		"main.mk:2:3",
+		"main.mk:5:31",
+		"main.mk:5:42",
+		"main.mk:8:6",
+	}
+
+	if len(rtErr.Frames) != len(expectedFrames) {
+		t.Fatalf("wrong number of frames. want=%d, got=%d (%+v)",
+			len(expectedFrames), len(rtErr.Frames), rtErr.Frames)
+	}
+
+	for i, want := range expectedFrames {
+		got := rtErr.Frames[i]
+		if got.Function != want.Function || got.Offset != want.Offset {
+			t.Errorf("frame %d wrong. want=%+v, got=%+v", i, want, got)
+		}
+		if got.Pos.String() != expectedPositions[i] {
+			t.Errorf("frame %d position wrong. want=%s, got=%s",
+				i, expectedPositions[i], got.Pos)
+		}
+	}
+
+	expectedTraceback := `main.mk:2:3: unsupported type for negation: BOOLEAN
+stack traceback:
+	inner
+		main.mk:2:3 (offset 0002)
+	<anonymous>
+		main.mk:5:31 (offset 0004)
+	outer
+		main.mk:5:42 (offset 0004)
+	<main>
+		main.mk:8:6 (offset 0017)
+`
+	if rtErr.Traceback() != expectedTraceback {
+		t.Errorf("wrong traceback.\nwant=%q\ngot =%q",
+			expectedTraceback, rtErr.Traceback())
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `let newClosure = fn(a) { fn() { a; }; };
+				let closure = newClosure(99);
+				closure();`,
+			expected: 99,
+		},
+		{
+			input: `let newAdder = fn(a, b) { fn(c) { a + b + c }; };
+				let adder = newAdder(1, 2);
+				adder(8);`,
+			expected: 11,
+		},
+		{
+			input: `let newAdder = fn(a, b) {
+				  let c = a + b;
+				  fn(d) { c + d };
+				};
+				let adder = newAdder(1, 2);
+				adder(8);`,
+			expected: 11,
+		},
+		{
+			input: `let newAdderOuter = fn(a, b) {
+				  let c = a + b;
+				  fn(d) {
+				    let e = d + c;
+				    fn(f) { e + f; };
+				  };
+				};
+				let newAdderInner = newAdderOuter(1, 2)
+				let adder = newAdderInner(3);
+				adder(8);`,
+			expected: 14,
+		},
+		{
+			input: `let a = 1;
+				let newAdderOuter = fn(b) {
+				  fn(c) {
+				    fn(d) { a + b + c + d };
+				  };
+				};
+				let newAdderInner = newAdderOuter(2)
+				let adder = newAdderInner(3);
+				adder(8);`,
+			expected: 14,
+		},
+		{
+			input: `let newClosure = fn(a, b) {
+				  let one = fn() { a; };
+				  let two = fn() { b; };
+				  fn() { one() + two(); };
+				};
+				let closure = newClosure(9, 90);
+				closure();`,
+			expected: 99,
+		},
+		{
+			input: `let x = 1;
+				let f = fn() { let x = x + 1; x };
+				f();`,
+			expected: 2,
+		},
+		{
+			input: `let f = fn(x) {
+				  let g = fn() { let x = x * 2; x };
+				  g() + x;
+				};
+				f(5);`,
+			expected: 15,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestLetValueCannotSeeItsOwnName(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse("let y = y; puts(y)"))
+	if err == nil || err.Error() != "1:9: undefined variable y" {
+		t.Fatalf("expected undefined variable y, got %v", err)
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `let countDown = fn(x) {
+				  if (x == 0) {
+				    return 0;
+				  } else {
+				    countDown(x - 1);
+				  }
+				};
+				countDown(1);`,
+			expected: 0,
+		},
+		{
+			input: `let countDown = fn(x) {
+				  if (x == 0) {
+				    return 0;
+				  } else {
+				    countDown(x - 1);
+				  }
+				};
+				let wrapper = fn() {
+				  countDown(1);
+				};
+				wrapper();`,
+			expected: 0,
+		},
+		{
+			input: `let wrapper = fn() {
+				  let countDown = fn(x) {
+				    if (x == 0) {
+				      return 0;
+				    } else {
+				      countDown(x - 1);
+				    }
+				  };
+				  countDown(1);
+				};
+				wrapper();`,
+			expected: 0,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			// far deeper than MaxFrames
+			input: `
+		let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } };
+		sum(100000, 0)`,
+			expected: 5000050000,
+		},
+		{
+			input: `
+		let count = fn(n) { if (n == 0) { return 0; }; return count(n - 1); };
+		count(5000)`,
+			expected: 0,
+		},
+		{
+			input: `
+		let loop = fn(n, f) { if (n == 0) { "done" } else { f(n - 1, f) } };
+		loop(5000, loop)`,
+			expected: "done",
+		},
+		{
+			input: `
+		let reduce = fn(arr, acc, f) {
+			if (len(arr) == 0) { acc } else { reduce(rest(arr), f(acc, first(arr)), f) }
+		};
+		reduce([1, 2, 3, 4], 0, fn(a, b) { a + b })`,
+			expected: 10,
+		},
+		{
+			// a different function, with other locals, in the same frame
+			input: `
+		let add = fn(a, b) { let c = a + b; c };
+		let twice = fn(a) { add(a, a) };
+		twice(4) + 1`,
+			expected: 9,
+		},
+		{
+			input:    `let size = fn(arr) { len(arr) }; size([1, 2, 3])`,
+			expected: 3,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `let fibonacci = fn(x) {
+				  if (x == 0) {
+				    return 0;
+				  } else {
+				    if (x == 1) {
+				      return 1;
+				    } else {
+				      fibonacci(x - 1) + fibonacci(x - 2);
+				    }
+				  }
+				};
+				fibonacci(15);`,
+			expected: 610,
+		},
+		{
+			input: `let wrapper = fn(n) {
+				  let fib = fn(n) {
+				    if (n < 2) { return n; }
+				    fib(n - 1) + fib(n - 2);
+				  };
+				  fib(n);
+				};
+				wrapper(10);`,
+			expected: 55,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestAssembledPrograms(t *testing.T) {
+	tests := []struct {
+		listing  string
+		expected any
+	}{
+		{
+			// a loop the compiler can't produce: sum 3 + 2 + 1
+			`.const
+			    int 0
+			    int 3
+			    int 1
+			.main
+			    OpConstant 0
+			    OpSetGlobal 0
+			    OpConstant 1
+			    OpSetGlobal 1
+			loop:
+			    OpGetGlobal 0
+			    OpGetGlobal 1
+			    OpAdd
+			    OpSetGlobal 0
+			    OpGetGlobal 1
+			    OpConstant 2
+			    OpSub
+			    OpSetGlobal 1
+			    OpGetGlobal 1
+			    OpConstant 0
+			    OpEqual
+			    OpBang
+			    OpJumpNotTruthy done
+			    OpJump loop
+			done:
+			    OpGetGlobal 0
+			    OpPop`,
+			6,
+		},
+		{
+			`.const
+			    #0 .func "twice" params=1 locals=1
+			        OpGetLocal 0
+			        OpGetLocal 0
+			        OpAdd
+			        OpReturnValue
+			    .end
+			    #1 string "ab"
+			.main
+			    OpClosure 0 0
+			    OpConstant 1
+			    OpCall 1
+			    OpPop`,
+			"abab",
+		},
+		{
+			// a return in main ends the program
+			`.main
+			    OpTrue
+			    OpReturnValue
+			    OpFalse
+			    OpPop`,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		bc, err := bytecode.Assemble(strings.NewReader(tt.listing))
 		if err != nil {
+			t.Fatalf("assemble error: %s", err)
+		}
+
+		vm := New(bc)
+		if err := vm.Run(); err != nil {
 			t.Fatalf("vm error: %s", err)
 		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	var constants, globals, locals, names []string
+	for i := 0; i <= 65536; i++ {
+		constants = append(constants, fmt.Sprint(i))
+		globals = append(globals, fmt.Sprintf("let %s = %d;", letters(i), i))
+	}
+	for i := 0; i <= 256; i++ {
+		locals = append(locals, fmt.Sprintf("let %s = %d;", letters(i), i))
+		names = append(names, letters(i))
+	}
+	body := strings.Repeat("0; ", 17000)
+
+	tests := []vmTestCase{
+		{strings.Join(constants, ";"), 65536},
+		{strings.Join(globals, " ") + letters(65535) + " + " + letters(65536), 131071},
+		{
+			fmt.Sprintf("fn() { %s %s + %s }()",
+				strings.Join(locals, " "), letters(255), letters(256)),
+			511,
+		},
+		{
+			// the inner function captures all 257 locals
+			fmt.Sprintf("fn() { %s fn() { %s } }()()",
+				strings.Join(locals, " "), strings.Join(names, " + ")),
+			32896,
+		},
+		{"if (true) { " + body + "1 } else { 2 }", 1},
+		{"if (false) { " + body + "1 } else { 2 }", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+// letters spells n in base 26 for identifiers, which can't hold digits;
+// the prefix keeps them clear of keywords
+func letters(n int) string {
+	name := string(rune('a' + n%26))
+	for n /= 26; n > 0; n /= 26 {
+		name = string(rune('a'+n%26)) + name
+	}
+	return "v" + name
+}
+
+func TestFrameStackPrecheck(t *testing.T) {
+	tests := []struct {
+		listing       string
+		expectedTrace []string // function names, innermost first
+	}{
+		{
+			fmt.Sprintf(".main stack=%d\nOpNull\nOpPop", StackSize+1),
+			[]string{"<main>"},
+		},
+		{
+			// the body would print before overflowing the stack
+			fmt.Sprintf(`.const
+			    .func "deep" stack=%d
+			        OpGetBuiltin 1
+			        OpNull
+			        OpCall 1
+			        OpReturnValue
+			    .end
+			.main
+			    OpClosure 0 0
+			    OpCall 0
+			    OpPop`, StackSize),
+			[]string{"<main>"},
+		},
+	}
+
+	for _, tt := range tests {
+		bc, err := bytecode.Assemble(strings.NewReader(tt.listing))
+		if err != nil {
+			t.Fatalf("assemble error: %s", err)
+		}
+
+		err = New(bc).Run()
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+		}
+		if rtErr.Message != "stack overflow" {
+			t.Errorf("wrong error. want=%q, got=%q", "stack overflow", rtErr.Message)
+		}
+		if len(rtErr.Frames) != len(tt.expectedTrace) {
+			t.Fatalf("wrong trace length. want=%d, got=%d",
+				len(tt.expectedTrace), len(rtErr.Frames))
+		}
+		for i, name := range tt.expectedTrace {
+			if rtErr.Frames[i].Function != name {
+				t.Errorf("wrong frame %d. want=%q, got=%q",
+					i, name, rtErr.Frames[i].Function)
+			}
+		}
+	}
+}
+
+func TestFloats(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.5", 3.5},
+		{"let a = 1.5; a * 2", 3.0},
+		{"let a = 7; a / 2.0", 3.5},
+		{"let a = 7; a / 2", 3},
+		{"let a = 0.1; a + 0.2", 0.30000000000000004},
+		{"let a = 2.5; -a", -2.5},
+		{"let a = 18446744073709551616; a * 0.5", 9223372036854775808.0},
+		{"let a = 1.0; a == 1", true},
+		{"let a = 1.5; a > 1", true},
+		{"let a = 2; a <= 1.99", false},
+		{"let a = 9007199254740993; a == 9007199254740992.0", false},
+		{"let nan = 0.0 * (1e308 * 10.0); nan == nan", false},
+		{"let nan = 0.0 * (1e308 * 10.0); nan != nan", true},
+		{"let h = {1.5: \"a\"}; let k = 3.0; h[k / 2]", "a"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBigIntegers(t *testing.T) {
+	twoTo64, _ := new(big.Int).SetString("18446744073709551616", 10)
+
+	tests := []vmTestCase{
+		{"18446744073709551616", twoTo64},
+		{"let a = 4294967296; a * a", twoTo64},
+		{"let a = 9223372036854775807; a + a + 2", twoTo64},
+		{"let a = 18446744073709551616; a - a", 0},
+		{"let a = 18446744073709551616; -a / 2 / 2 / 4", -1152921504606846976},
+		{"let a = 18446744073709551616; a > 1", true},
+		{"let a = 18446744073709551616; 1 >= a", false},
+		{"let a = 18446744073709551616; a == 18446744073709551616", true},
+		{"let a = 18446744073709551616; a != a + 1", true},
+		{"let a = 9223372036854775807; a + 1 == 9223372036854775808", true},
+		{"let h = {18446744073709551616: 1}; let a = 4294967296; h[a * a]", 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		input    string
+		policy   object.Overflow
+		expected any // an error message is a string starting with "error: "
+	}{
+		{"let max = 9223372036854775807; max + 1", object.OverflowWrap, math.MinInt64},
+		{"let max = 9223372036854775807; max + 1", object.OverflowError, "error: integer overflow"},
+		{"let max = 9223372036854775807; max + 1", object.OverflowPromote, "9223372036854775808"},
+		{"let max = 9223372036854775807; max * max / max", object.OverflowPromote, math.MaxInt64},
+		{"let min = -9223372036854775807 - 1; -min", object.OverflowWrap, math.MinInt64},
+		{"let min = -9223372036854775807 - 1; -min", object.OverflowError, "error: integer overflow"},
+		{"let min = -9223372036854775807 - 1; min / -1", object.OverflowPromote, "9223372036854775808"},
+		{"let big = 4611686018427387904 * 4; big - big", object.OverflowPromote, 0},
+		{"let big = 4611686018427387904 * 4; big / 0", object.OverflowPromote, "error: division by zero"},
+		{"9223372036854775807 + 1", object.OverflowError, "error: integer overflow"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.Overflow = tt.policy
+		err := vm.Run()
+
+		switch expected := tt.expected.(type) {
+		case string:
+			if msg, ok := strings.CutPrefix(expected, "error: "); ok {
+				if err == nil || err.(*RuntimeError).Message != msg {
+					t.Errorf("%q (%s): wrong error. want=%q, got=%v",
+						tt.input, tt.policy, msg, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%q (%s): vm error: %s", tt.input, tt.policy, err)
+			}
+			big, ok := vm.LastPoppedStackElem().(*object.BigInt)
+			if !ok || big.Value.String() != expected {
+				t.Errorf("%q (%s): want BigInt %s, got %s",
+					tt.input, tt.policy, expected, vm.LastPoppedStackElem().Inspect())
+			}
+		default:
+			if err != nil {
+				t.Fatalf("%q (%s): vm error: %s", tt.input, tt.policy, err)
+			}
+			testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+		}
+	}
+}
+
+func TestGrowingLimits(t *testing.T) {
+	input := `let depth = fn(n) { if (n == 0) { 0 } else { 1 + depth(n - 1) } };
+depth(%d)`
+
+	tests := []struct {
+		depth      int
+		stackLimit int
+		frameLimit int
+		expected   string // the error, empty if the run succeeds
+	}{
+		{5000, StackSize, MaxFrames, ""},
+		{MaxFrames, StackSize, MaxFrames, "maximum recursion depth exceeded"},
+		{8, StackSize, 10, ""}, // main is one of the frames
+		{9, StackSize, 10, "maximum recursion depth exceeded"},
+		{100, 200, MaxFrames, "stack overflow"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(fmt.Sprintf(input, tt.depth))); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.StackLimit = tt.stackLimit
+		vm.FrameLimit = tt.frameLimit
+		err := vm.Run()
+
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("depth %d: vm error: %s", tt.depth, err)
+				continue
+			}
+			testExpectedObject(t, tt.depth, vm.LastPoppedStackElem())
+			continue
+		}
+
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Errorf("depth %d: expected *RuntimeError, got %T (%v)", tt.depth, err, err)
+			continue
+		}
+		if rtErr.Message != tt.expected {
+			t.Errorf("depth %d: wrong error. want=%q, got=%q",
+				tt.depth, tt.expected, rtErr.Message)
+		}
+	}
+}
+
+func TestRecursionTraceback(t *testing.T) {
+	input := `let f = fn(n) { 1 + f(n + 1) };
+f(0)`
+	p := parser.New(lexer.NewWithFilename("main.mk", input))
+	comp := compiler.New()
+	if err := comp.Compile(p.ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	vm.FrameLimit = 5
+	rtErr, ok := vm.Run().(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError")
+	}
 
-		stackElem := vm.LastPoppedStackElem()
-		testExpectedObject(t, tt.expected, stackElem)
+	expected := `main.mk:1:22: maximum recursion depth exceeded
+stack traceback:
+	f
+		main.mk:1:22 (offset 0010)
+	... repeated 3 more times
+	<main>
+		main.mk:2:2 (offset 0013)
+`
+	if rtErr.Traceback() != expected {
+		t.Errorf("wrong traceback.\nwant=%q\ngot =%q", expected, rtErr.Traceback())
+	}
+}
+
+func TestGlobalsGrowLazily(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let a = 1; let b = 2; a + b")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if len(vm.Globals()) != 2 {
+		t.Errorf("wrong globals size. want=2, got=%d", len(vm.Globals()))
+	}
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	// the peephole optimizer must not change any result
+	for _, optimize := range []bool{false, true} {
+		for _, tt := range tests {
+			program := parse(tt.input)
+
+			comp := compiler.New()
+			comp.Optimize = optimize
+			err := comp.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			if err := Verify(comp.Bytecode()); err != nil {
+				t.Fatalf("verify error: %s", err)
+			}
+
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+
+			stackElem := vm.LastPoppedStackElem()
+			testExpectedObject(t, tt.expected, stackElem)
+		}
 	}
 }
 
@@ -267,6 +1189,18 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testBooleanObject failed: %s", err)
 		}
+	case float64:
+		float, ok := actual.(*object.Float)
+		if !ok || float.Value != expected {
+			t.Errorf("object is not Float %g. got=%T (%+v)",
+				expected, actual, actual.Inspect())
+		}
+	case *big.Int:
+		integer, ok := actual.(*object.BigInt)
+		if !ok || integer.Value.Cmp(expected) != 0 {
+			t.Errorf("object is not BigInt %s. got=%T (%+v)",
+				expected, actual, actual.Inspect())
+		}
 	case string:
 		err := testStringObject(expected, actual)
 		if err != nil {